@@ -0,0 +1,254 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redacted replaces the values of sensitive parameters in access logs.
+const redacted = "[REDACTED]"
+
+// combinedTimeFormat is the time format used by the Combined Log Format.
+const combinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// An AccessLog logs each request handled by the router it observes.  By
+// default, each request is logged as a slog record with the message
+// "request", and the attributes method, host, path, route, template, params,
+// status, bytes, duration, and remote_addr.  Requests that result in a server
+// error are logged at slog.LevelError, and all others at slog.LevelInfo.
+type AccessLog struct {
+	logger     *slog.Logger
+	sampleRate float64
+	sensitive  map[string]bool
+	combined   io.Writer
+	mu         sync.Mutex // Guards writes to combined
+}
+
+// NewAccessLog returns a new AccessLog that logs to l, or to slog.Default if
+// l is nil.
+func NewAccessLog(l *slog.Logger) *AccessLog {
+	return &AccessLog{
+		logger:     l,
+		sampleRate: 1,
+	}
+}
+
+// SetSampleRate sets the fraction of requests that are logged, between 0 and
+// 1.  Requests that result in a server error are always logged.  The default
+// is 1.
+func (l *AccessLog) SetSampleRate(r float64) *AccessLog {
+	l.sampleRate = r
+	return l
+}
+
+// SampleRate returns the fraction of requests that are logged.
+func (l *AccessLog) SampleRate() float64 {
+	return l.sampleRate
+}
+
+// SetSensitiveParams sets the list of route parameters whose values are
+// replaced with "[REDACTED]", both in the logged params and in the path.
+func (l *AccessLog) SetSensitiveParams(p ...string) *AccessLog {
+	l.sensitive = make(map[string]bool, len(p))
+	for _, v := range p {
+		l.sensitive[v] = true
+	}
+	return l
+}
+
+// SensitiveParams returns the list of route parameters whose values are
+// redacted.
+func (l *AccessLog) SensitiveParams() []string {
+	p := make([]string, 0, len(l.sensitive))
+	for k := range l.sensitive {
+		p = append(p, k)
+	}
+	return p
+}
+
+// SetCombinedFormat sets the access log to write each request to w in the
+// Apache Combined Log Format, instead of logging it with slog.
+func (l *AccessLog) SetCombinedFormat(w io.Writer) *AccessLog {
+	l.combined = w
+	return l
+}
+
+// UnsetCombinedFormat sets the access log to log with slog.
+func (l *AccessLog) UnsetCombinedFormat() {
+	l.combined = nil
+}
+
+// Observe logs the request once its response is complete.  An AccessLog is
+// added to a router as an observer, so that every request the router handles
+// is logged with the status that was sent to the client, including requests
+// that match no route, or that are rejected before reaching a handler, such
+// as by authentication, rate limiting, or a timeout:
+//
+//	router.SetObservers(accessLog)
+func (l *AccessLog) Observe(w http.ResponseWriter, req *http.Request, route *Route) (http.ResponseWriter, func()) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+	return sw, func() {
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		if sw.status < http.StatusInternalServerError && l.sampleRate < 1 && rand.Float64() >= l.sampleRate {
+			return
+		}
+		r := &Request{Request: req, Route: route, Params: routeParams(req, route)}
+		if l.combined != nil {
+			l.writeCombined(r, sw, start)
+		} else {
+			l.log(r, sw, time.Since(start))
+		}
+	}
+}
+
+// log logs the request with slog.
+func (l *AccessLog) log(req *Request, sw *statusWriter, duration time.Duration) {
+	logger := l.logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	level := slog.LevelInfo
+	if sw.status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+
+	keys := make([]string, 0, len(req.Params))
+	for k := range req.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	params := make([]any, 0, len(keys))
+	for _, k := range keys {
+		v := req.Params[k]
+		if l.sensitive[k] {
+			v = redacted
+		}
+		params = append(params, slog.String(k, v))
+	}
+
+	name, template := "", ""
+	if req.Route != nil {
+		name, template = req.Route.Name(), routeTemplate(req.Route)
+	}
+	logger.LogAttrs(req.Request.Context(), level, "request",
+		slog.String("method", req.Request.Method),
+		slog.String("host", req.Host()),
+		slog.String("path", l.redactPath(req)),
+		slog.String("route", name),
+		slog.String("template", template),
+		slog.Group("params", params...),
+		slog.Int("status", sw.status),
+		slog.Int64("bytes", sw.bytes),
+		slog.Duration("duration", duration),
+		slog.String("remote_addr", req.ClientIP()),
+	)
+}
+
+// writeCombined writes the request in the Combined Log Format.
+func (l *AccessLog) writeCombined(req *Request, sw *statusWriter, start time.Time) {
+	user := "-"
+	if name, _, ok := req.Request.BasicAuth(); ok && name != "" {
+		user = name
+	} else if req.Request.URL.User != nil && req.Request.URL.User.Username() != "" {
+		user = req.Request.URL.User.Username()
+	}
+	bytes := "-"
+	if sw.bytes > 0 {
+		bytes = strconv.FormatInt(sw.bytes, 10)
+	}
+	uri := l.redactPath(req)
+	if req.Request.URL.RawQuery != "" {
+		uri += "?" + req.Request.URL.RawQuery
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s %s %s\n",
+		req.ClientIP(),
+		user,
+		start.Format(combinedTimeFormat),
+		req.Request.Method,
+		uri,
+		req.Request.Proto,
+		sw.status,
+		bytes,
+		quoteCombined(req.Request.Referer()),
+		quoteCombined(req.Request.UserAgent()),
+	)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.combined, line)
+}
+
+// redactPath returns the request's path, with the values of any sensitive
+// path parameters replaced.  Only the portions of the path matched by those
+// parameters are replaced, so values that also appear elsewhere in the path
+// are left alone.
+func (l *AccessLog) redactPath(req *Request) string {
+	route, path := req.Route, req.Request.URL.Path
+	if route == nil || route.path == nil || len(l.sensitive) == 0 {
+		return req.Request.URL.EscapedPath()
+	}
+	spans := route.path.paramSpans(path)
+	var b strings.Builder
+	last := 0
+	for i, span := range spans {
+		if span[0] < span[1] && l.sensitive[route.path.params[i][0]] {
+			b.WriteString(escapePathValue(path[last:span[0]]))
+			b.WriteString(redacted)
+			last = span[1]
+		}
+	}
+	if last == 0 {
+		return req.Request.URL.EscapedPath()
+	}
+	b.WriteString(escapePathValue(path[last:]))
+	return b.String()
+}
+
+// routeParams returns the host and path parameters of route, which matched
+// req, or no parameters if route is nil.
+func routeParams(req *http.Request, route *Route) map[string]string {
+	if route == nil {
+		return map[string]string{}
+	}
+	params, err := route.getPathParams(req.URL.Path)
+	if err != nil {
+		params = make(map[string]string)
+	}
+	for k, v := range route.getHostParams(req) {
+		if _, exists := params[k]; !exists {
+			params[k] = v
+		}
+	}
+	return params
+}
+
+// escapePathValue escapes v in the same way as a URL path.
+func escapePathValue(v string) string {
+	u := &url.URL{Path: v}
+	return u.EscapedPath()
+}
+
+// quoteCombined quotes s for the Combined Log Format, using "-" if s is
+// empty.
+func quoteCombined(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,185 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	accessLog := NewAccessLog(slog.New(slog.NewJSONHandler(buf, nil))).SetSensitiveParams("token")
+	if !slicesAreSimilar(accessLog.SensitiveParams(), []string{"token"}) {
+		t.Errorf("Expected sensitive params '[token]', received '%v'.", accessLog.SensitiveParams())
+	}
+
+	router := NewRouter().SetObservers(accessLog)
+	router.NewRoute().SetName("reset").SetPath("/users/{id:[0-9]+}/reset/{token:[a-z0-9]+}").
+		SetHandler(func(w http.ResponseWriter, req *Request) {
+			w.Write([]byte("hello"))
+		})
+
+	req, _ := http.NewRequest("POST", "http://example.com/users/42/reset/s3cr3t", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("Expected a JSON record, received '%v'.", buf.String())
+	}
+	expected := map[string]interface{}{
+		"msg":         "request",
+		"level":       "INFO",
+		"method":      "POST",
+		"host":        "example.com",
+		"path":        "/users/42/reset/[REDACTED]",
+		"route":       "reset",
+		"template":    "/users/{id:[0-9]+}/reset/{token:[a-z0-9]+}",
+		"status":      float64(200),
+		"bytes":       float64(5),
+		"remote_addr": "192.0.2.1",
+	}
+	for k, v := range expected {
+		if record[k] != v {
+			t.Errorf("Expected %v '%v', received '%v'.", k, v, record[k])
+		}
+	}
+	params, _ := record["params"].(map[string]interface{})
+	if params["id"] != "42" || params["token"] != "[REDACTED]" {
+		t.Errorf("Expected redacted params, received '%v'.", record["params"])
+	}
+	if _, ok := record["duration"]; !ok {
+		t.Error("Expected a duration, received none.")
+	}
+	if strings.Contains(buf.String(), "s3cr3t") {
+		t.Errorf("Expected the token to be redacted, received '%v'.", buf.String())
+	}
+}
+
+func TestAccessLog_sampling(t *testing.T) {
+	buf := new(bytes.Buffer)
+	accessLog := NewAccessLog(slog.New(slog.NewTextHandler(buf, nil))).SetSampleRate(0)
+	if accessLog.SampleRate() != 0 {
+		t.Errorf("Expected sample rate '0', received '%v'.", accessLog.SampleRate())
+	}
+
+	router := NewRouter().SetObservers(accessLog)
+	router.NewRoute().SetPath("/ok").SetHandler(func(w http.ResponseWriter, req *Request) {})
+	router.NewRoute().SetPath("/error").SetHandler(func(w http.ResponseWriter, req *Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	// Server errors are always logged.
+	for _, path := range []string{"/ok", "/error"} {
+		req, _ := http.NewRequest("GET", "http://example.com"+path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "level=ERROR") || !strings.Contains(lines[0], "status=502") {
+		t.Errorf("Expected a single error record, received '%v'.", buf.String())
+	}
+}
+
+func TestAccessLog_combined(t *testing.T) {
+	buf := new(bytes.Buffer)
+	accessLog := NewAccessLog(nil).SetCombinedFormat(buf).SetSensitiveParams("token")
+
+	router := NewRouter().SetObservers(accessLog)
+	router.NewRoute().SetPath("/reset/{token:[a-z0-9]+}").SetHandler(func(w http.ResponseWriter, req *Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	req, _ := http.NewRequest("GET", "http://example.com/reset/s3cr3t?lang=en", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.SetBasicAuth("frank", "password")
+	req.Header.Set("Referer", "http://example.com/start")
+	req.Header.Set("User-Agent", `Agent "1.0"`)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	pattern := `^192\.0\.2\.1 - frank \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] ` +
+		`"GET /reset/\[REDACTED\]\?lang=en HTTP/1\.1" 404 - "http://example\.com/start" "Agent \\"1\.0\\""` + "\n$"
+	if !regexp.MustCompile(pattern).MatchString(buf.String()) {
+		t.Errorf("Expected a Combined Log Format line, received '%v'.", buf.String())
+	}
+}
+
+func TestAccessLog_redactPath(t *testing.T) {
+	accessLog := NewAccessLog(nil).SetSensitiveParams("id", "token")
+	handler := func(w http.ResponseWriter, req *Request) {}
+	router := NewRouter()
+	router.NewRoute().SetPath("/users/{id}/users").SetHandler(handler)
+	router.NewRoute().SetPath("/reset/{token}/{page}").SetHandler(handler)
+	router.NewRoute().SetPath("/files/{name:*}").SetHandler(handler)
+
+	type redactTest struct {
+		path     string
+		expected string
+	}
+
+	tests := []redactTest{
+		{"/users/s/users", "/users/[REDACTED]/users"},     // 0
+		{"/users/users/users", "/users/[REDACTED]/users"}, // 1
+		{"/reset/abc/abc", "/reset/[REDACTED]/abc"},       // 2
+		{"/reset/a%20b/x", "/reset/[REDACTED]/x"},         // 3
+		{"/files/a%20b/c", "/files/a%20b/c"},              // 4
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com"+test.path, nil)
+		m, err := router.Match(req)
+		if err != nil {
+			t.Fatalf("tests[%v]: Expected no error, received '%v'.", i, err)
+		}
+		path := accessLog.redactPath(&Request{Request: req, Route: m.Route, Params: m.Params})
+		if path != test.expected {
+			t.Errorf("tests[%v]: Expected path '%v', received '%v'.", i, test.expected, path)
+		}
+	}
+}
+
+func TestAccessLog_rejected(t *testing.T) {
+	buf := new(bytes.Buffer)
+	accessLog := NewAccessLog(slog.New(slog.NewTextHandler(buf, nil)))
+	auth := BearerAuth("api", func(token string) *Principal { return nil })
+
+	router := NewRouter().SetObservers(accessLog)
+	router.NewRoute().SetName("slow").SetPath("/slow").SetTimeout(10 * time.Millisecond).
+		SetHandler(func(w http.ResponseWriter, req *Request) {
+			<-req.Request.Context().Done()
+		})
+	router.NewRoute().SetName("private").SetPath("/private").Require(auth).
+		SetHandler(func(w http.ResponseWriter, req *Request) {})
+
+	type rejectedTest struct {
+		path   string
+		status string
+		route  string
+	}
+
+	// Responses sent before, or instead of, the route's handler are logged
+	// with the status the client received.
+	tests := []rejectedTest{
+		{"/slow", "status=503", "route=slow"},       // 0
+		{"/private", "status=401", "route=private"}, // 1
+		{"/missing", "status=404", "route=\"\""},    // 2
+	}
+
+	for i, test := range tests {
+		buf.Reset()
+		req, _ := http.NewRequest("GET", "http://example.com"+test.path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		if !strings.Contains(buf.String(), test.status) || !strings.Contains(buf.String(), test.route) {
+			t.Errorf("requests[%v]: Expected '%v' and '%v', received '%v'.", i, test.status, test.route, buf.String())
+		}
+	}
+}
@@ -0,0 +1,426 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors returned by authenticators.
+var (
+	// ErrNoCredentials is returned when a request does not carry any
+	// credentials that the authenticator understands.
+	ErrNoCredentials = errors.New("routing: Request has no credentials.")
+	// ErrInvalidCredentials is returned when a request carries credentials
+	// that were rejected.
+	ErrInvalidCredentials = errors.New("routing: Credentials are invalid.")
+	// ErrTokenExpired is returned when a JWT has expired, or is not valid
+	// yet.
+	ErrTokenExpired = errors.New("routing: Token has expired or is not valid yet.")
+)
+
+// principalKey is the context key under which the authenticated Principal is
+// stored.
+type principalKey struct{}
+
+// A Principal is the authenticated client making a request.
+type Principal struct {
+	// ID identifies the client, such as a user name, or the subject of a
+	// JWT.
+	ID string
+	// Roles and Permissions are what the client is allowed to do.  For a
+	// JWT, they are read from the "roles" claim and the "scope" claim.
+	Roles       []string
+	Permissions []string
+	// Claims holds any other information about the client, such as the
+	// claims of a JWT.
+	Claims map[string]interface{}
+}
+
+// An Authenticator identifies the client making a request.
+type Authenticator interface {
+	// Authenticate returns the client making req.  If req does not carry any
+	// credentials that the Authenticator understands, ErrNoCredentials is
+	// returned.
+	Authenticate(req *http.Request) (*Principal, error)
+	// Challenge returns the WWW-Authenticate challenge sent to a client that
+	// failed to authenticate because of err.
+	Challenge(err error) string
+}
+
+// BasicAuth returns an Authenticator for HTTP Basic authentication.  verify
+// is called with the credentials sent by the client, and returns the client
+// they identify, or nil if they are invalid.  It should compare passwords in
+// constant time, such as with crypto/subtle.
+func BasicAuth(realm string, verify func(username, password string) *Principal) Authenticator {
+	return &basicAuth{realm: realm, verify: verify}
+}
+
+// basicAuth implements HTTP Basic authentication.
+type basicAuth struct {
+	realm  string
+	verify func(username, password string) *Principal
+}
+
+// Authenticate implements Authenticator.
+func (a *basicAuth) Authenticate(req *http.Request) (*Principal, error) {
+	if authScheme(req) != "basic" {
+		return nil, ErrNoCredentials
+	}
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if p := a.verify(username, password); p != nil {
+		return p, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// Challenge implements Authenticator.
+func (a *basicAuth) Challenge(err error) string {
+	return `Basic realm=` + quoteAuthParam(a.realm) + `, charset="UTF-8"`
+}
+
+// BearerAuth returns an Authenticator for opaque bearer tokens, as defined by
+// RFC 6750.  verify is called with the token sent by the client, and returns
+// the client it identifies, or nil if it is invalid.
+func BearerAuth(realm string, verify func(token string) *Principal) Authenticator {
+	return &bearerAuth{realm: realm, verify: verify}
+}
+
+// bearerAuth implements bearer token authentication.
+type bearerAuth struct {
+	realm  string
+	verify func(token string) *Principal
+}
+
+// Authenticate implements Authenticator.
+func (a *bearerAuth) Authenticate(req *http.Request) (*Principal, error) {
+	token, err := bearerToken(req)
+	if err != nil {
+		return nil, err
+	}
+	if p := a.verify(token); p != nil {
+		return p, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// Challenge implements Authenticator.
+func (a *bearerAuth) Challenge(err error) string {
+	return bearerChallenge(a.realm, err)
+}
+
+// A JWTAuth authenticates clients by a JSON Web Token sent as a bearer token.
+// Tokens must be signed with the algorithm that the JWTAuth was created for,
+// and any "exp" and "nbf" claims are checked.
+type JWTAuth struct {
+	realm    string
+	alg      string
+	secret   []byte
+	key      *rsa.PublicKey
+	issuer   string
+	audience string
+	leeway   time.Duration
+	check    func(claims map[string]interface{}) error
+}
+
+// NewHS256Auth returns a new JWTAuth that verifies tokens signed with
+// HMAC-SHA256 using secret.
+func NewHS256Auth(secret []byte) *JWTAuth {
+	return &JWTAuth{alg: "HS256", secret: secret}
+}
+
+// NewRS256Auth returns a new JWTAuth that verifies tokens signed with
+// RSASSA-PKCS1-v1_5 using SHA-256 and key.
+func NewRS256Auth(key *rsa.PublicKey) *JWTAuth {
+	return &JWTAuth{alg: "RS256", key: key}
+}
+
+// SetRealm sets the realm sent in challenges.
+func (a *JWTAuth) SetRealm(realm string) *JWTAuth {
+	a.realm = realm
+	return a
+}
+
+// Realm returns the realm sent in challenges.
+func (a *JWTAuth) Realm() string {
+	return a.realm
+}
+
+// SetIssuer sets the issuer that tokens must have in their "iss" claim.
+func (a *JWTAuth) SetIssuer(iss string) *JWTAuth {
+	a.issuer = iss
+	return a
+}
+
+// Issuer returns the issuer that tokens must have.
+func (a *JWTAuth) Issuer() string {
+	return a.issuer
+}
+
+// SetAudience sets the audience that tokens must include in their "aud"
+// claim.
+func (a *JWTAuth) SetAudience(aud string) *JWTAuth {
+	a.audience = aud
+	return a
+}
+
+// Audience returns the audience that tokens must include.
+func (a *JWTAuth) Audience() string {
+	return a.audience
+}
+
+// SetLeeway sets how much clock skew is allowed when checking the "exp" and
+// "nbf" claims.
+func (a *JWTAuth) SetLeeway(d time.Duration) *JWTAuth {
+	a.leeway = d
+	return a
+}
+
+// Leeway returns how much clock skew is allowed.
+func (a *JWTAuth) Leeway() time.Duration {
+	return a.leeway
+}
+
+// SetClaimsCheck sets a function that is called with the claims of each
+// token that has a valid signature.  If it returns an error, the token is
+// rejected.
+func (a *JWTAuth) SetClaimsCheck(f func(claims map[string]interface{}) error) *JWTAuth {
+	a.check = f
+	return a
+}
+
+// UnsetClaimsCheck clears the function that checks the claims of tokens.
+func (a *JWTAuth) UnsetClaimsCheck() {
+	a.check = nil
+}
+
+// Authenticate implements Authenticator.
+func (a *JWTAuth) Authenticate(req *http.Request) (*Principal, error) {
+	token, err := bearerToken(req)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := a.verify(token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	p := &Principal{
+		Roles:  claimStrings(claims["roles"]),
+		Claims: claims,
+	}
+	p.ID, _ = claims["sub"].(string)
+	if scope, ok := claims["scope"].(string); ok {
+		p.Permissions = strings.Fields(scope)
+	}
+	return p, nil
+}
+
+// Challenge implements Authenticator.
+func (a *JWTAuth) Challenge(err error) string {
+	return bearerChallenge(a.realm, err)
+}
+
+// verify checks the signature and claims of token, and returns its claims.
+func (a *JWTAuth) verify(token string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCredentials
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil || json.Unmarshal(b, &header) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	// The algorithm is fixed by the JWTAuth, so that tokens cannot choose
+	// "none", or have an RSA public key used as an HMAC secret.
+	if header.Alg != a.alg {
+		return nil, ErrInvalidCredentials
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch a.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, ErrInvalidCredentials
+		}
+	case "RS256":
+		sum := sha256.Sum256(signed)
+		if a.key == nil || rsa.VerifyPKCS1v15(a.key, crypto.SHA256, sum[:], sig) != nil {
+			return nil, ErrInvalidCredentials
+		}
+	}
+
+	var claims map[string]interface{}
+	if b, err := base64.RawURLEncoding.DecodeString(parts[1]); err != nil || json.Unmarshal(b, &claims) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if exp, ok := claims["exp"]; ok {
+		t, ok := exp.(float64)
+		if !ok || !now.Before(time.Unix(int64(t), 0).Add(a.leeway)) {
+			return nil, ErrTokenExpired
+		}
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		t, ok := nbf.(float64)
+		if !ok || now.Before(time.Unix(int64(t), 0).Add(-a.leeway)) {
+			return nil, ErrTokenExpired
+		}
+	}
+	if a.issuer != "" && claims["iss"] != a.issuer {
+		return nil, ErrInvalidCredentials
+	}
+	if a.audience != "" && !sliceContainsString(claimStrings(claims["aud"]), a.audience) {
+		return nil, ErrInvalidCredentials
+	}
+	if a.check != nil {
+		if err := a.check(claims); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// claimStrings returns the value of a claim that is either a string, or an
+// array of strings.
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var s []string
+		for _, e := range v {
+			if e, ok := e.(string); ok {
+				s = append(s, e)
+			}
+		}
+		return s
+	}
+	return nil
+}
+
+// authScheme returns the lowercased scheme of req's Authorization header.
+func authScheme(req *http.Request) string {
+	scheme, _, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+	return strings.ToLower(scheme)
+}
+
+// bearerToken returns the bearer token sent with req.
+func bearerToken(req *http.Request) (string, error) {
+	if authScheme(req) != "bearer" {
+		return "", ErrNoCredentials
+	}
+	_, token, _ := strings.Cut(req.Header.Get("Authorization"), " ")
+	if token = strings.TrimSpace(token); token == "" {
+		return "", ErrInvalidCredentials
+	}
+	return token, nil
+}
+
+// bearerChallenge returns a Bearer challenge for the given realm, which
+// reports err as an invalid token unless no token was sent.  The description
+// sent to the client is fixed, so that the details of err are not revealed.
+func bearerChallenge(realm string, err error) string {
+	c := `Bearer realm=` + quoteAuthParam(realm)
+	switch err {
+	case nil, ErrNoCredentials:
+	case ErrTokenExpired:
+		c += `, error="invalid_token", error_description="token expired"`
+	default:
+		c += `, error="invalid_token", error_description="invalid token"`
+	}
+	return c
+}
+
+// quoteAuthParam returns s as a quoted string.
+func quoteAuthParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Require sets the authenticators that a client must pass one of to access
+// the route.  Requests that fail to authenticate receive a 401 Unauthorized
+// response, with a WWW-Authenticate challenge from each authenticator, and
+// no handlers are called.  Otherwise, the client is available from
+// Request.Principal.
+//
+// A route requires authentication if it, or any route above it in a Subroute
+// tree, does.  The authenticators of the last matched route that has any are
+// used.
+func (r *Route) Require(auths ...Authenticator) *Route {
+	r.auth = auths
+	return r
+}
+
+// Required returns the authenticators that a client must pass one of to
+// access the route.
+func (r *Route) Required() []Authenticator {
+	return r.auth
+}
+
+// UnsetRequired clears the authenticators that a client must pass one of to
+// access the route.
+func (r *Route) UnsetRequired() {
+	r.auth = nil
+}
+
+// Principal returns the authenticated client making the request.  If the
+// route does not require authentication, nil is returned.
+func (r *Request) Principal() *Principal {
+	p, _ := r.Request.Context().Value(principalKey{}).(*Principal)
+	return p
+}
+
+// requiredAuth returns the authenticators of the last route in chain that
+// has any.
+func requiredAuth(chain []*Route) []Authenticator {
+	for i := len(chain) - 1; i >= 0; i-- {
+		if len(chain[i].auth) > 0 {
+			return chain[i].auth
+		}
+	}
+	return nil
+}
+
+// withAuth returns h wrapped so that only clients that pass one of auths can
+// access it.
+func (r *Router) withAuth(auths []Authenticator, h HandlerFunc) HandlerFunc {
+	return func(w http.ResponseWriter, req *Request) {
+		errs := make([]error, len(auths))
+		for i, a := range auths {
+			p, err := a.Authenticate(req.Request)
+			if err == nil && p != nil {
+				req.Request = req.Request.WithContext(context.WithValue(req.Request.Context(), principalKey{}, p))
+				h(w, req)
+				return
+			}
+			if err == nil {
+				err = ErrInvalidCredentials
+			}
+			errs[i] = err
+		}
+		for i, a := range auths {
+			w.Header().Add("WWW-Authenticate", a.Challenge(errs[i]))
+		}
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// signJWT returns a token with the given header and claims, signed with key,
+// which is either an HMAC secret or an RSA private key.
+func signJWT(header, claims map[string]interface{}, key interface{}) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	var sig []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTAuth(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	hs256 := NewHS256Auth(secret).SetIssuer("issuer").SetAudience("api").SetLeeway(time.Minute)
+	rs256 := NewRS256Auth(&rsaKey.PublicKey).SetClaimsCheck(func(claims map[string]interface{}) error {
+		if claims["tenant"] != "acme" {
+			return errors.New("wrong tenant")
+		}
+		return nil
+	})
+	now := time.Now().Unix()
+
+	type jwtTest struct {
+		auth   *JWTAuth
+		header map[string]interface{}
+		claims map[string]interface{}
+		key    interface{}
+		err    error
+	}
+
+	hs := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	rs := map[string]interface{}{"alg": "RS256", "typ": "JWT"}
+	valid := map[string]interface{}{"sub": "1", "iss": "issuer", "aud": []string{"web", "api"}, "exp": now + 60}
+	tests := []jwtTest{
+		{hs256, hs, valid, secret, nil},                                                                               // 0
+		{hs256, hs, valid, []byte("wrong"), ErrInvalidCredentials},                                                    // 1
+		{hs256, map[string]interface{}{"alg": "none"}, valid, secret, ErrInvalidCredentials},                          // 2
+		{hs256, hs, map[string]interface{}{"iss": "issuer", "aud": "api", "exp": now - 30}, secret, nil},              // 3
+		{hs256, hs, map[string]interface{}{"iss": "issuer", "aud": "api", "exp": now - 120}, secret, ErrTokenExpired}, // 4
+		{hs256, hs, map[string]interface{}{"iss": "issuer", "aud": "api", "nbf": now + 120}, secret, ErrTokenExpired}, // 5
+		{hs256, hs, map[string]interface{}{"iss": "other", "aud": "api"}, secret, ErrInvalidCredentials},              // 6
+		{hs256, hs, map[string]interface{}{"iss": "issuer", "aud": "web"}, secret, ErrInvalidCredentials},             // 7
+		{rs256, rs, map[string]interface{}{"tenant": "acme"}, rsaKey, nil},                                            // 8
+		{rs256, rs, map[string]interface{}{"tenant": "other"}, rsaKey, errors.New("wrong tenant")},                    // 9
+		// An RS256 token signed with HS256 must be rejected.
+		{rs256, hs, map[string]interface{}{"tenant": "acme"}, secret, ErrInvalidCredentials}, // 10
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("Authorization", "Bearer "+signJWT(test.header, test.claims, test.key))
+		_, err := test.auth.Authenticate(req)
+		if !reflect.DeepEqual(err, test.err) {
+			t.Errorf("tests[%v]: Expected error '%v', received '%v'.", i, test.err, err)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Authorization", "Bearer "+signJWT(hs, map[string]interface{}{
+		"sub": "42", "iss": "issuer", "aud": "api", "roles": []string{"admin"}, "scope": "read write",
+	}, secret))
+	p, err := hs256.Authenticate(req)
+	if err != nil || p.ID != "42" || !reflect.DeepEqual(p.Roles, []string{"admin"}) || !reflect.DeepEqual(p.Permissions, []string{"read", "write"}) {
+		t.Errorf("Expected principal '42' with role 'admin' and two permissions, received '%v' and error '%v'.", p, err)
+	}
+}
+
+func TestJWTAuth_challenge(t *testing.T) {
+	auth := NewHS256Auth([]byte("secret")).SetRealm("api")
+	tests := []struct {
+		err       error
+		challenge string
+	}{
+		{nil, `Bearer realm="api"`},              // 0
+		{ErrNoCredentials, `Bearer realm="api"`}, // 1
+		{ErrInvalidCredentials, `Bearer realm="api", error="invalid_token", error_description="invalid token"`}, // 2
+		{ErrTokenExpired, `Bearer realm="api", error="invalid_token", error_description="token expired"`},       // 3
+		// The details of other errors are not sent to the client.
+		{errors.New("wrong tenant"), `Bearer realm="api", error="invalid_token", error_description="invalid token"`}, // 4
+	}
+	for i, test := range tests {
+		if c := auth.Challenge(test.err); c != test.challenge {
+			t.Errorf("tests[%v]: Expected challenge '%v', received '%v'.", i, test.challenge, c)
+		}
+	}
+}
+
+func TestRouteRequire(t *testing.T) {
+	var principal *Principal
+	handler := func(w http.ResponseWriter, req *Request) {
+		principal = req.Principal()
+	}
+	basic := BasicAuth("admin", func(username, password string) *Principal {
+		if username == "alice" && password == "secret" {
+			return &Principal{ID: "alice", Roles: []string{"admin"}}
+		}
+		return nil
+	})
+	bearer := BearerAuth("api", func(token string) *Principal {
+		if token == "token" {
+			return &Principal{ID: "service"}
+		}
+		return nil
+	})
+	router := NewRouter()
+	router.NewRoute().SetPath("/public").SetHandler(handler)
+	admin := router.NewRoute().SetPrefix("/admin/").Require(basic, bearer)
+	admin.Subroute().SetPath("/users").SetHandler(handler)
+	// Routes created before Require is called are still guarded.
+	api := router.NewRoute().SetPrefix("/api/")
+	api.Subroute().SetPath("/items").SetHandler(handler)
+	api.Require(bearer)
+
+	type requireTest struct {
+		path      string
+		auth      string
+		code      int
+		principal string
+		challenge []string
+	}
+
+	tests := []requireTest{
+		{"/public", "", http.StatusOK, "", nil}, // 0
+		{"/admin/users", "", http.StatusUnauthorized, "", []string{`Basic realm="admin", charset="UTF-8"`, `Bearer realm="api"`}},                             // 1
+		{"/admin/users", "Basic YWxpY2U6c2VjcmV0", http.StatusOK, "alice", nil},                                                                               // 2
+		{"/admin/users", "Bearer token", http.StatusOK, "service", nil},                                                                                       // 3
+		{"/admin/users", "Basic YWxpY2U6d3Jvbmc=", http.StatusUnauthorized, "", []string{`Basic realm="admin", charset="UTF-8"`, `Bearer realm="api"`}},       // 4
+		{"/api/items", "Bearer wrong", http.StatusUnauthorized, "", []string{`Bearer realm="api", error="invalid_token", error_description="invalid token"`}}, // 5
+		{"/api/items", "bearer token", http.StatusOK, "service", nil},                                                                                         // 6
+	}
+
+	for i, test := range tests {
+		principal = nil
+		req, _ := http.NewRequest("GET", "http://example.com"+test.path, nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("tests[%v]: Expected status '%v', received '%v'.", i, test.code, w.Code)
+		}
+		if challenge := w.Header()["Www-Authenticate"]; !reflect.DeepEqual(challenge, test.challenge) {
+			t.Errorf("tests[%v]: Expected challenge '%v', received '%v'.", i, test.challenge, challenge)
+		}
+		if test.principal == "" && principal != nil || test.principal != "" && (principal == nil || principal.ID != test.principal) {
+			t.Errorf("tests[%v]: Expected principal '%v', received '%v'.", i, test.principal, principal)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error messages related to CORS.
+const (
+	errCORSWildcardCredentials = "routing: A CORS policy that allows credentials can not allow any origin with '*'."
+)
+
+// A CORSPolicy describes which cross-origin requests are allowed, as defined by
+// the Fetch standard.  Origins are matched exactly, ignoring case, unless they
+// are "*", which matches any origin, or contain a wildcard subdomain, such as
+// "https://*.example.com", which matches any subdomain of example.com over
+// https, but not example.com itself.
+type CORSPolicy struct {
+	origins        []string
+	patterns       []*regexp.Regexp
+	credentials    bool
+	allowedHeaders []string
+	exposedHeaders []string
+	maxAge         time.Duration
+}
+
+// NewCORSPolicy returns a new CORSPolicy that allows the given origins.
+func NewCORSPolicy(origins ...string) *CORSPolicy {
+	return &CORSPolicy{origins: origins}
+}
+
+// SetOrigins sets the origins that are allowed.
+func (p *CORSPolicy) SetOrigins(origins ...string) *CORSPolicy {
+	p.origins = origins
+	return p
+}
+
+// Origins returns the origins that are allowed.
+func (p *CORSPolicy) Origins() []string {
+	return p.origins
+}
+
+// SetOriginPatterns sets regular expressions, any of which allows the origins
+// that it matches, in addition to those set by SetOrigins.
+func (p *CORSPolicy) SetOriginPatterns(patterns ...*regexp.Regexp) *CORSPolicy {
+	p.patterns = patterns
+	return p
+}
+
+// OriginPatterns returns the regular expressions that allow the origins they
+// match.
+func (p *CORSPolicy) OriginPatterns() []*regexp.Regexp {
+	return p.patterns
+}
+
+// SetCredentials sets whether requests can include credentials, such as
+// cookies.  Since the Fetch standard forbids allowing credentials from any
+// origin, the "*" origin does not match anything while credentials are
+// allowed, and only origins that are listed explicitly, or matched by a
+// wildcard subdomain or pattern, are allowed.
+func (p *CORSPolicy) SetCredentials(credentials bool) *CORSPolicy {
+	p.credentials = credentials
+	return p
+}
+
+// Credentials returns true if requests can include credentials.
+func (p *CORSPolicy) Credentials() bool {
+	return p.credentials
+}
+
+// SetAllowedHeaders sets the request headers that are allowed.  If no headers
+// are set, any headers requested by a preflight request are allowed.
+func (p *CORSPolicy) SetAllowedHeaders(headers ...string) *CORSPolicy {
+	p.allowedHeaders = headers
+	return p
+}
+
+// AllowedHeaders returns the request headers that are allowed.
+func (p *CORSPolicy) AllowedHeaders() []string {
+	return p.allowedHeaders
+}
+
+// SetExposedHeaders sets the response headers that scripts are allowed to
+// read, beyond the CORS-safelisted ones.
+func (p *CORSPolicy) SetExposedHeaders(headers ...string) *CORSPolicy {
+	p.exposedHeaders = headers
+	return p
+}
+
+// ExposedHeaders returns the response headers that scripts are allowed to
+// read.
+func (p *CORSPolicy) ExposedHeaders() []string {
+	return p.exposedHeaders
+}
+
+// SetMaxAge sets how long the result of a preflight request can be cached.
+func (p *CORSPolicy) SetMaxAge(d time.Duration) *CORSPolicy {
+	p.maxAge = d
+	return p
+}
+
+// MaxAge returns how long the result of a preflight request can be cached.
+func (p *CORSPolicy) MaxAge() time.Duration {
+	return p.maxAge
+}
+
+// SetCORS sets the CORS policy of routes that do not have one of their own.
+//
+// Preflight requests, which are OPTIONS requests with an Origin and an
+// Access-Control-Request-Method header, are answered by the router without
+// calling any handlers.  The policy of the route that matches the requested
+// method is used, and the methods of every route that matches the request's
+// path are advertised in Access-Control-Allow-Methods.  Since preflight
+// requests do not carry the headers of the request that they ask about,
+// routes are matched without regard to their header and WebSocket
+// constraints.  Preflight requests that are not allowed by the policy, or for
+// which no route matches, are answered with 403.
+//
+// If p allows credentials along with the "*" origin, an error message is set
+// on the router.  See CORSPolicy.SetCredentials.
+func (r *Router) SetCORS(p *CORSPolicy) *Router {
+	if p != nil && p.wildcardCredentials() {
+		r.err = fmt.Errorf(errCORSWildcardCredentials)
+	}
+	r.cors = p
+	return r
+}
+
+// CORS returns the CORS policy of routes that do not have one of their own.
+func (r *Router) CORS() *CORSPolicy {
+	return r.cors
+}
+
+// UnsetCORS clears the CORS policy of routes that do not have one of their
+// own.
+func (r *Router) UnsetCORS() {
+	r.cors = nil
+}
+
+// SetCORS sets the route's CORS policy, which is used in place of the
+// router's.  If p allows credentials along with the "*" origin, an error
+// message is set on the route.  See Router.SetCORS.
+func (r *Route) SetCORS(p *CORSPolicy) *Route {
+	if p != nil && p.wildcardCredentials() {
+		r.err = fmt.Errorf(errCORSWildcardCredentials)
+	}
+	r.cors = p
+	return r
+}
+
+// CORS returns the route's CORS policy.
+func (r *Route) CORS() *CORSPolicy {
+	return r.cors
+}
+
+// UnsetCORS clears the route's CORS policy.
+func (r *Route) UnsetCORS() {
+	r.cors = nil
+}
+
+// allowsOrigin returns true if the policy allows origin.
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, v := range p.origins {
+		if v == "*" {
+			if !p.credentials {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(v, origin) {
+			return true
+		}
+		if before, after, ok := strings.Cut(v, "*"); ok && len(origin) > len(before)+len(after) &&
+			strings.HasPrefix(strings.ToLower(origin), strings.ToLower(before)) &&
+			strings.HasSuffix(strings.ToLower(origin), strings.ToLower(after)) {
+			// The wildcard must only cover subdomain labels.
+			if sub := origin[len(before) : len(origin)-len(after)]; !strings.ContainsAny(sub, "/:") {
+				return true
+			}
+		}
+	}
+	for _, re := range p.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHeaders returns true if the policy allows all of the headers.
+func (p *CORSPolicy) allowsHeaders(headers []string) bool {
+	if p.allowedHeaders == nil {
+		return true
+	}
+	for _, h := range headers {
+		allowed := false
+		for _, v := range p.allowedHeaders {
+			if strings.EqualFold(h, v) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// wildcardCredentials returns true if the policy allows credentials along
+// with the "*" origin.
+func (p *CORSPolicy) wildcardCredentials() bool {
+	return p.credentials && sliceContainsString(p.origins, "*")
+}
+
+// setOrigin sets the headers that allow the request's origin.
+func (p *CORSPolicy) setOrigin(h http.Header, origin string) {
+	if sliceContainsString(p.origins, "*") && !p.credentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// preflightKey is the context key that marks a request as standing in for
+// the request that a preflight request asks about.  Such requests are matched
+// without regard to headers or WebSocket handshakes, since preflight requests
+// do not carry the headers of the request that they ask about.
+type preflightKey struct{}
+
+// isPreflightTarget returns true if req stands in for the request that a
+// preflight request asks about.
+func isPreflightTarget(req *http.Request) bool {
+	target, _ := req.Context().Value(preflightKey{}).(bool)
+	return target
+}
+
+// isPreflight returns true if req is a CORS preflight request.
+func isPreflight(req *http.Request) bool {
+	return req.Method == "OPTIONS" && req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsPolicy returns the CORS policy that applies to route.
+func (r *Router) corsPolicy(route *Route) *CORSPolicy {
+	if route != nil && route.cors != nil {
+		return route.cors
+	}
+	return r.cors
+}
+
+// preflightTarget returns the request that a preflight request asks about,
+// which has the requested method.
+func preflightTarget(req *http.Request) *http.Request {
+	target := req.WithContext(context.WithValue(req.Context(), preflightKey{}, true))
+	target.Method = req.Header.Get("Access-Control-Request-Method")
+	return target
+}
+
+// preflightRoute returns the route that matches the request a preflight
+// request asks about, if any.  If no CORS policy applies to that route, false
+// is returned, and the preflight is handled as any other request.
+func (r *Router) preflightRoute(req *http.Request) (*Route, bool) {
+	m, err := r.match(preflightTarget(req))
+	if err != nil {
+		return nil, false
+	}
+	return m.Route, r.corsPolicy(m.Route) != nil
+}
+
+// servePreflight answers a preflight request, which asks about a request
+// matched to route.  A CORS policy must apply to route.  See
+// Router.preflightRoute.
+func (r *Router) servePreflight(w http.ResponseWriter, req *http.Request, route *Route) {
+	requested := req.Header.Get("Access-Control-Request-Method")
+	policy := r.corsPolicy(route)
+
+	// Without a method, only routes that match any method are matched, so
+	// every other route that matches the path is collected.
+	target := preflightTarget(req)
+	target.Method = ""
+	allowed := allowedMethods(target, r.routes)
+	if route != nil && !sliceContainsString(allowed, requested) {
+		allowed = append(allowed, requested)
+		sort.Strings(allowed)
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	origin := req.Header.Get("Origin")
+	headers := splitHeader(req.Header["Access-Control-Request-Headers"])
+	if route == nil || !policy.allowsOrigin(origin) || !policy.allowsHeaders(headers) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	policy.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if policy.maxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.maxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyCORS sets the CORS headers of the response to a request matched to
+// route.
+func (r *Router) applyCORS(w http.ResponseWriter, req *http.Request, route *Route) {
+	policy := r.corsPolicy(route)
+	if policy == nil {
+		return
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	origin := req.Header.Get("Origin")
+	if !policy.allowsOrigin(origin) {
+		return
+	}
+	policy.setOrigin(h, origin)
+	if len(policy.exposedHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(policy.exposedHeaders, ", "))
+	}
+}
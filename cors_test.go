@@ -0,0 +1,219 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestCORSPolicyOrigins(t *testing.T) {
+	policy := NewCORSPolicy("https://app.example.com", "https://*.example.org").
+		SetOriginPatterns(regexp.MustCompile(`^http://localhost:[0-9]+$`))
+
+	type originTest struct {
+		origin  string
+		allowed bool
+	}
+
+	tests := []originTest{
+		{"https://app.example.com", true},        // 0
+		{"HTTPS://APP.EXAMPLE.COM", true},        // 1
+		{"http://app.example.com", false},        // 2
+		{"https://a.example.org", true},          // 3
+		{"https://a.b.example.org", true},        // 4
+		{"https://example.org", false},           // 5
+		{"https://.example.org", false},          // 6
+		{"https://evil.com/.example.org", false}, // 7
+		{"https://a.example.org:8443", false},    // 8
+		{"http://localhost:3000", true},          // 9
+		{"", false},                              // 10
+	}
+
+	for i, test := range tests {
+		if allowed := policy.allowsOrigin(test.origin); allowed != test.allowed {
+			t.Errorf("tests[%v]: Expected allowed to be '%v', received '%v'.", i, test.allowed, allowed)
+		}
+	}
+}
+
+func TestRouterCORS(t *testing.T) {
+	called := false
+	handler := func(w http.ResponseWriter, req *Request) {
+		called = true
+		w.Header().Set("X-Total-Count", "1")
+	}
+	router := NewRouter().SetCORS(NewCORSPolicy("https://app.example.com").
+		SetAllowedHeaders("Content-Type", "Authorization").
+		SetExposedHeaders("X-Total-Count").
+		SetMaxAge(10 * time.Minute))
+	router.NewRoute().SetPath("/items").SetMethods("GET", "POST").SetHandler(handler)
+	router.NewRoute().SetPath("/items").SetMethods("DELETE").SetHandler(handler)
+	public := router.NewRoute().SetPrefix("/public/").SetCORS(NewCORSPolicy("*")).SetHandler(handler)
+	public.Subroute().SetPath("/feed").SetMethods("GET").SetHandler(handler)
+
+	type corsTest struct {
+		method       string
+		path         string
+		origin       string
+		reqMethod    string
+		reqHeaders   string
+		status       int
+		allowOrigin  string
+		allowMethods string
+		called       bool
+	}
+
+	tests := []corsTest{
+		{ // 0
+			method: "OPTIONS", path: "/items", origin: "https://app.example.com", reqMethod: "DELETE", reqHeaders: "content-type",
+			status: http.StatusNoContent, allowOrigin: "https://app.example.com", allowMethods: "DELETE, GET, POST",
+		},
+		{ // 1
+			method: "OPTIONS", path: "/items", origin: "https://evil.example.com", reqMethod: "GET",
+			status: http.StatusForbidden,
+		},
+		{ // 2
+			method: "OPTIONS", path: "/items", origin: "https://app.example.com", reqMethod: "PUT",
+			status: http.StatusForbidden,
+		},
+		{ // 3
+			method: "OPTIONS", path: "/items", origin: "https://app.example.com", reqMethod: "POST", reqHeaders: "X-Secret",
+			status: http.StatusForbidden,
+		},
+		{ // 4
+			method: "GET", path: "/items", origin: "https://app.example.com",
+			status: http.StatusOK, allowOrigin: "https://app.example.com", called: true,
+		},
+		{ // 5
+			method: "GET", path: "/items", origin: "https://evil.example.com",
+			status: http.StatusOK, called: true,
+		},
+		{ // 6
+			// Routes can have their own policy, which subroutes inherit.
+			method: "OPTIONS", path: "/public/feed", origin: "https://anywhere.test", reqMethod: "GET",
+			status: http.StatusNoContent, allowOrigin: "*", allowMethods: "GET",
+		},
+		{ // 7
+			// Routes that match any method advertise the requested method.
+			method: "OPTIONS", path: "/public/", origin: "https://anywhere.test", reqMethod: "PATCH",
+			status: http.StatusNoContent, allowOrigin: "*", allowMethods: "PATCH",
+		},
+		{ // 8
+			// Plain OPTIONS requests are not preflights.
+			method: "OPTIONS", path: "/items",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for i, test := range tests {
+		called = false
+		req, _ := http.NewRequest(test.method, "http://example.com"+test.path, nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		if test.reqMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", test.reqMethod)
+		}
+		if test.reqHeaders != "" {
+			req.Header.Set("Access-Control-Request-Headers", test.reqHeaders)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("tests[%v]: Expected status '%v', received '%v'.", i, test.status, w.Code)
+		}
+		if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != test.allowOrigin {
+			t.Errorf("tests[%v]: Expected origin '%v', received '%v'.", i, test.allowOrigin, origin)
+		}
+		if methods := w.Header().Get("Access-Control-Allow-Methods"); methods != test.allowMethods {
+			t.Errorf("tests[%v]: Expected methods '%v', received '%v'.", i, test.allowMethods, methods)
+		}
+		if called != test.called {
+			t.Errorf("tests[%v]: Expected called to be '%v', received '%v'.", i, test.called, called)
+		}
+	}
+
+	// Successful preflights and requests include the remaining headers.
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "Authorization")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Max-Age") != "600" || w.Header().Get("Access-Control-Allow-Headers") != "Authorization" {
+		t.Errorf("Expected max age '600' and headers 'Authorization', received '%v'.", w.Header())
+	}
+	req, _ = http.NewRequest("GET", "http://example.com/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Expose-Headers") != "X-Total-Count" || w.Header().Get("Vary") != "Origin" {
+		t.Errorf("Expected exposed headers and vary, received '%v'.", w.Header())
+	}
+
+	// Credentials require the origin to be echoed.
+	router.SetCORS(NewCORSPolicy("https://*.example.com").SetCredentials(true))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" || w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("Expected the origin to be echoed with credentials, received '%v'.", w.Header())
+	}
+
+	// Credentials are never allowed for any origin.
+	router.SetCORS(NewCORSPolicy("*").SetCredentials(true))
+	if router.Error() == nil {
+		t.Error("Expected an error for '*' with credentials, received none.")
+	}
+	req.Header.Set("Origin", "https://evil.example")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("Expected the origin to be refused, received '%v'.", w.Header())
+	}
+	if NewRouter().NewRoute().SetCORS(NewCORSPolicy("*").SetCredentials(true)).Error() == nil {
+		t.Error("Expected a route error for '*' with credentials, received none.")
+	}
+}
+
+func TestRouterCORS_constraints(t *testing.T) {
+	handler := func(w http.ResponseWriter, req *Request) {}
+	router := NewRouter().SetCORS(NewCORSPolicy("https://app.example.com"))
+	router.NewRoute().SetPath("/x").SetMethods("GET").SetHandler(handler)
+	router.NewRoute().SetPath("/x").SetMethods("POST").SetHeader("X-Key", "a").SetHandler(handler)
+	router.NewRoute().SetPath("/live").WebSocket().SetHandler(handler)
+
+	type constraintTest struct {
+		path         string
+		reqMethod    string
+		status       int
+		allowMethods string
+	}
+
+	tests := []constraintTest{
+		{"/x", "POST", http.StatusNoContent, "GET, POST"}, // 0
+		{"/x", "GET", http.StatusNoContent, "GET, POST"},  // 1
+		{"/x", "PUT", http.StatusForbidden, ""},           // 2
+		{"/live", "GET", http.StatusNoContent, "GET"},     // 3
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest("OPTIONS", "http://example.com"+test.path, nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", test.reqMethod)
+		req.Header.Set("Access-Control-Request-Headers", "x-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("tests[%v]: Expected status '%v', received '%v'.", i, test.status, w.Code)
+		}
+		if methods := w.Header().Get("Access-Control-Allow-Methods"); methods != test.allowMethods {
+			t.Errorf("tests[%v]: Expected methods '%v', received '%v'.", i, test.allowMethods, methods)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FilesParam is the name of the parameter that holds the path of the requested
+// file, relative to the prefix given to Route.ServeFiles.
+const FilesParam = "filepath"
+
+// precompressedEncodings lists the content encodings that a FileServer looks
+// for precompressed variants of, in order of preference, along with the file
+// extension of each variant.
+var precompressedEncodings = []struct {
+	encoding, ext string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Cache-Control values used for fallback files and hashed assets.
+const (
+	cacheFallback = "no-cache"
+	cacheHashed   = "public, max-age=31536000, immutable"
+)
+
+// defaultHashedAssets matches the names of files that include a hash of their
+// content, such as "main.3f2a9c1b.js" or "app-3f2a9c1b.css".
+var defaultHashedAssets = regexp.MustCompile(`[.-][0-9a-f]{8,}\.[^.]+$`)
+
+// A FileServer serves files from an fs.FS.  Files are served with a
+// Content-Type based on their extension or content, and with an ETag and a
+// Last-Modified header, so that conditional and Range requests are supported.
+//
+// A FileServer can be used on its own as an http.Handler, in which case the
+// request's path is the path of the file, or passed to Route.ServeFiles in
+// place of the fs.FS it wraps.
+type FileServer struct {
+	fsys          fs.FS
+	index         []string
+	listing       bool
+	precompressed bool
+	fallback      string
+	excludes      []string
+	hashed        *regexp.Regexp
+}
+
+// NewFileServer returns a new FileServer that serves the files in fsys.  By
+// default, "index.html" is served for directories, directory listings are
+// disabled, and precompressed variants are served when available.
+func NewFileServer(fsys fs.FS) *FileServer {
+	return &FileServer{
+		fsys:          fsys,
+		index:         []string{"index.html"},
+		precompressed: true,
+	}
+}
+
+// Open opens the named file in the underlying fs.FS, so that a FileServer can
+// be used wherever an fs.FS is expected.
+func (s *FileServer) Open(name string) (fs.File, error) {
+	return s.fsys.Open(name)
+}
+
+// SetIndex sets the names of the files that are served for a directory, in
+// order of preference.  If no names are given, or none exist, the directory
+// is listed if listings are enabled.
+func (s *FileServer) SetIndex(names ...string) *FileServer {
+	s.index = names
+	return s
+}
+
+// Index returns the names of the files that are served for a directory.
+func (s *FileServer) Index() []string {
+	return s.index
+}
+
+// SetListing sets whether directories without an index file are listed.  If
+// not, requests for them result in a not found response.
+func (s *FileServer) SetListing(listing bool) *FileServer {
+	s.listing = listing
+	return s
+}
+
+// Listing returns true if directories without an index file are listed.
+func (s *FileServer) Listing() bool {
+	return s.listing
+}
+
+// SetPrecompressed sets whether precompressed variants of files are served.
+// If enabled, and the client accepts the encoding, "name.br" or "name.gz" is
+// served in place of "name" when it exists, with the Content-Type of "name".
+func (s *FileServer) SetPrecompressed(precompressed bool) *FileServer {
+	s.precompressed = precompressed
+	return s
+}
+
+// Precompressed returns true if precompressed variants of files are served.
+func (s *FileServer) Precompressed() bool {
+	return s.precompressed
+}
+
+// SetFallback sets the file that is served in place of files that do not
+// exist, as is needed by single page applications that route on the client.
+// The fallback is only served for GET and HEAD requests that accept
+// "text/html", and whose path has no file extension and is not excluded.  It
+// is served with "Cache-Control: no-cache", so that clients always revalidate
+// it.
+func (s *FileServer) SetFallback(name string) *FileServer {
+	s.fallback = name
+	return s
+}
+
+// Fallback returns the file that is served in place of files that do not
+// exist.
+func (s *FileServer) Fallback() string {
+	return s.fallback
+}
+
+// UnsetFallback clears the file that is served in place of files that do not
+// exist.
+func (s *FileServer) UnsetFallback() {
+	s.fallback = ""
+}
+
+// SetFallbackExcludes sets the request path prefixes, such as "/api", that the
+// fallback is never served for.  Prefixes only match whole path segments.
+func (s *FileServer) SetFallbackExcludes(prefixes ...string) *FileServer {
+	s.excludes = prefixes
+	return s
+}
+
+// FallbackExcludes returns the request path prefixes that the fallback is
+// never served for.
+func (s *FileServer) FallbackExcludes() []string {
+	return s.excludes
+}
+
+// SetHashedAssets sets the pattern that matches the names of files that
+// include a hash of their content.  Since such files never change, they are
+// served with a Cache-Control header that allows them to be cached for a
+// year.
+func (s *FileServer) SetHashedAssets(re *regexp.Regexp) *FileServer {
+	s.hashed = re
+	return s
+}
+
+// HashedAssets returns the pattern that matches the names of files that
+// include a hash of their content.
+func (s *FileServer) HashedAssets() *regexp.Regexp {
+	return s.hashed
+}
+
+// UnsetHashedAssets clears the pattern that matches the names of files that
+// include a hash of their content.
+func (s *FileServer) UnsetHashedAssets() {
+	s.hashed = nil
+}
+
+// ServeHTTP serves the file named by the request's path.  Requests for
+// directories without a trailing slash, and for files with one, are
+// redirected.
+func (s *FileServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.serve(w, req, strings.TrimPrefix(req.URL.Path, "/"), true)
+}
+
+// ServeFiles sets the route to serve the files in fsys under prefix.  The
+// route matches prefix and everything below it, and only GET and HEAD
+// requests.  The path of the requested file is available as the "filepath"
+// parameter.  To change how files are served, pass a FileServer as fsys.
+//
+// Directories are served with a trailing slash, and files without one.  If
+// the route matches trailing slashes, requests that do not follow this are
+// redirected, and otherwise they are not found.  Paths that are not valid
+// within fsys, such as those containing ".." elements, are never served.
+func (r *Route) ServeFiles(prefix string, fsys fs.FS) *Route {
+	s, ok := fsys.(*FileServer)
+	if !ok {
+		s = NewFileServer(fsys)
+	}
+
+	// Slashes are handled here, since directories and files differ.
+	redirect := r.matchSlashes
+	r.matchSlashes = false
+	parsedPath, err := r.parsePath(strings.TrimSuffix(prefix, "/")+"/{"+FilesParam+":*}", false)
+	if err != nil {
+		r.matchSlashes = redirect
+		r.err = err
+		return r
+	}
+	// Also match the prefix itself, so that it can be redirected.
+	pattern := strings.TrimSuffix(parsedPath.fwdPattern.String(), "/(.*)$")
+	parsedPath.fwdPattern = regexp.MustCompile(pattern + "(?:/(.*))?$")
+	r.path = parsedPath
+
+	r.methods = map[string]bool{"GET": true, "HEAD": true}
+	r.handler = func(w http.ResponseWriter, req *Request) {
+		s.serve(w, req.Request, req.Params[FilesParam], redirect)
+	}
+	return r
+}
+
+// ServeSPA sets the route to serve the single page application in fsys, such
+// as an embed.FS, under prefix.  It is the same as ServeFiles, except that
+// "index.html" is served for any path that is not a file, and names that
+// include a hexadecimal hash, such as "main.3f2a9c1b.js", are cached for a
+// year.  Paths under "/api" are not found as usual.  To change any of these,
+// pass a FileServer as fsys, in which case only its fallback and hashed asset
+// pattern are set if they are not already.
+func (r *Route) ServeSPA(prefix string, fsys fs.FS) *Route {
+	s, ok := fsys.(*FileServer)
+	if !ok {
+		s = NewFileServer(fsys).SetFallbackExcludes("/api")
+	}
+	if s.fallback == "" {
+		s.fallback = "index.html"
+	}
+	if s.hashed == nil {
+		s.hashed = defaultHashedAssets
+	}
+	return r.ServeFiles(prefix, s)
+}
+
+// serve serves the file with the given name, which is relative to the root of
+// the file system.  Whether a directory or a file was requested depends on
+// the trailing slash of the request's path.  If redirect is true, requests
+// with the wrong trailing slash are redirected, and otherwise they are not
+// found.
+func (s *FileServer) serve(w http.ResponseWriter, req *http.Request, name string, redirect bool) {
+	trailingSlash := strings.HasSuffix(req.URL.Path, "/")
+	if name = strings.TrimSuffix(name, "/"); name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) || strings.ContainsAny(name, "\\\x00") {
+		http.NotFound(w, req)
+		return
+	}
+
+	f, err := s.fsys.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && s.fallsBack(req, name) {
+			name = s.fallback
+			f, err = s.fsys.Open(name)
+		}
+		if err != nil {
+			serveFileError(w, req, err)
+			return
+		}
+		trailingSlash = false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		serveFileError(w, req, err)
+		return
+	}
+
+	if info.IsDir() != trailingSlash {
+		if !redirect {
+			http.NotFound(w, req)
+			return
+		}
+		if trailingSlash {
+			redirectTo(w, req, strings.TrimSuffix(req.URL.Path, "/"))
+		} else {
+			redirectTo(w, req, req.URL.Path+"/")
+		}
+		return
+	}
+	if !info.IsDir() {
+		s.serveFile(w, req, name, f, info)
+		return
+	}
+
+	for _, index := range s.index {
+		indexName := path.Join(name, index)
+		indexFile, err := s.fsys.Open(indexName)
+		if err != nil {
+			continue
+		}
+		defer indexFile.Close()
+		if indexInfo, err := indexFile.Stat(); err == nil && !indexInfo.IsDir() {
+			s.serveFile(w, req, indexName, indexFile, indexInfo)
+			return
+		}
+	}
+	if !s.listing {
+		http.NotFound(w, req)
+		return
+	}
+	serveListing(w, req, f)
+}
+
+// fallsBack returns true if the fallback should be served in place of the
+// file with the given name, which does not exist.
+func (s *FileServer) fallsBack(req *http.Request, name string) bool {
+	if s.fallback == "" || (req.Method != "GET" && req.Method != "HEAD") {
+		return false
+	}
+	if path.Ext(name) != "" || !accepts(req.Header["Accept"], "text/html") {
+		return false
+	}
+	for _, prefix := range s.excludes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if req.URL.Path == prefix || strings.HasPrefix(req.URL.Path, prefix+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// serveFile serves the opened file f, or a precompressed variant of it.
+func (s *FileServer) serveFile(w http.ResponseWriter, req *http.Request, name string, f fs.File, info fs.FileInfo) {
+	switch {
+	case s.fallback != "" && name == path.Clean(s.fallback):
+		w.Header().Set("Cache-Control", cacheFallback)
+	case s.hashed != nil && s.hashed.MatchString(path.Base(name)):
+		w.Header().Set("Cache-Control", cacheHashed)
+	}
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if s.precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+		for _, enc := range precompressedEncodings {
+			if !accepts(req.Header["Accept-Encoding"], enc.encoding) {
+				continue
+			}
+			variant, err := s.fsys.Open(name + enc.ext)
+			if err != nil {
+				continue
+			}
+			defer variant.Close()
+			variantInfo, err := variant.Stat()
+			if err != nil || variantInfo.IsDir() {
+				continue
+			}
+			if ctype == "" {
+				// Sniff the type from the uncompressed file.
+				buf := make([]byte, 512)
+				n, _ := io.ReadFull(f, buf)
+				ctype = http.DetectContentType(buf[:n])
+			}
+			w.Header().Set("Content-Encoding", enc.encoding)
+			f, info = variant, variantInfo
+			break
+		}
+	}
+	if ctype != "" {
+		w.Header().Set("Content-Type", ctype)
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			serveFileError(w, req, err)
+			return
+		}
+		content = bytes.NewReader(b)
+	}
+	etag, err := fileETag(info, content)
+	if err != nil {
+		serveFileError(w, req, err)
+		return
+	}
+	w.Header().Set("Etag", etag)
+	http.ServeContent(w, req, name, info.ModTime(), content)
+}
+
+// fileETag returns a strong ETag for a file.  Files with a modification time
+// are identified by it and their size, and those without one, such as those
+// in an embed.FS, by a hash of their content.
+func fileETag(info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	if !info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()), nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`, nil
+}
+
+// accepts returns true if the values of a header such as Accept or
+// Accept-Encoding include v with a non-zero quality.
+func accepts(values []string, v string) bool {
+	for _, part := range splitHeader(values) {
+		value, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(value), v) {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(q, 64); err == nil && f == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// serveListing serves a listing of the directory d.
+func serveListing(w http.ResponseWriter, req *http.Request, d fs.File) {
+	dir, ok := d.(fs.ReadDirFile)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		serveFileError(w, req, err)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, "<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		href := url.URL{Path: name}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", html.EscapeString(href.String()), html.EscapeString(name))
+	}
+	fmt.Fprint(w, "</pre>\n")
+}
+
+// serveFileError responds to an error opening or reading a file.
+func serveFileError(w http.ResponseWriter, req *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, req)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// redirectTo permanently redirects the request to p, which is relative to
+// any mount point, keeping the query string.
+func redirectTo(w http.ResponseWriter, req *http.Request, p string) {
+	location := mountPrefix(req) + p
+	if req.URL.RawQuery != "" {
+		location += "?" + req.URL.RawQuery
+	}
+	http.Redirect(w, req, location, http.StatusMovedPermanently)
+}
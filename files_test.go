@@ -0,0 +1,288 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCatchAllParam(t *testing.T) {
+	route := NewRouter().NewRoute().SetPath("/files/{path:*}")
+	if route.Error() != nil {
+		t.Fatalf("Expected no error, received '%v'.", route.Error())
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/files/a/b/c.txt", nil)
+	if params, _ := route.getPathParams(req.URL.Path); params["path"] != "a/b/c.txt" {
+		t.Errorf("Expected path 'a/b/c.txt', received '%v'.", params["path"])
+	}
+
+	if route = NewRouter().NewRoute().SetPath("/files/{path:*}/edit"); route.Error() == nil {
+		t.Error("Expected an error for a catch-all parameter that is not last, received none.")
+	}
+	if route = NewRouter().NewRoute().SetPath("/users/{id}"); route.Error() != nil {
+		t.Errorf("Expected no error, received '%v'.", route.Error())
+	}
+}
+
+func TestRouteServeFiles(t *testing.T) {
+	modTime := time.Date(2013, 6, 1, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"index.html":       {Data: []byte("<p>home</p>"), ModTime: modTime},
+		"app.js":           {Data: []byte("console.log('app');"), ModTime: modTime},
+		"app.js.gz":        {Data: []byte("gzipped"), ModTime: modTime},
+		"app.js.br":        {Data: []byte("brotli"), ModTime: modTime},
+		"docs/readme.txt":  {Data: []byte("0123456789"), ModTime: modTime},
+		"docs/guide/a.txt": {Data: []byte("a"), ModTime: modTime},
+	}
+
+	router := NewRouter()
+	router.NewRoute().SetMatchSlashes(true).ServeFiles("/static/", fsys)
+	router.NewRoute().ServeFiles("/strict", NewFileServer(fsys).SetListing(true).SetPrecompressed(false))
+
+	type fileTest struct {
+		method   string
+		path     string
+		headers  http.Header
+		status   int
+		body     string
+		location string
+		encoding string
+	}
+
+	tests := []fileTest{
+		{ // 0
+			path:   "/static/",
+			status: http.StatusOK,
+			body:   "<p>home</p>",
+		},
+		{ // 1
+			path:     "/static",
+			status:   http.StatusMovedPermanently,
+			location: "/static/",
+		},
+		{ // 2
+			path:     "/static/docs?page=1",
+			status:   http.StatusMovedPermanently,
+			location: "/static/docs/?page=1",
+		},
+		{ // 3
+			path:     "/static/app.js/",
+			status:   http.StatusMovedPermanently,
+			location: "/static/app.js",
+		},
+		{ // 4
+			path:   "/static/app.js",
+			status: http.StatusOK,
+			body:   "console.log('app');",
+		},
+		{ // 5
+			path:     "/static/app.js",
+			headers:  http.Header{"Accept-Encoding": {"gzip, br"}},
+			status:   http.StatusOK,
+			body:     "brotli",
+			encoding: "br",
+		},
+		{ // 6
+			path:     "/static/app.js",
+			headers:  http.Header{"Accept-Encoding": {"gzip, br;q=0"}},
+			status:   http.StatusOK,
+			body:     "gzipped",
+			encoding: "gzip",
+		},
+		{ // 7
+			path:    "/static/docs/readme.txt",
+			headers: http.Header{"Range": {"bytes=2-4"}},
+			status:  http.StatusPartialContent,
+			body:    "234",
+		},
+		{ // 8
+			// Directories without an index are not listed by default.
+			path:   "/static/docs/",
+			status: http.StatusNotFound,
+		},
+		{ // 9
+			path:   "/static/missing.txt",
+			status: http.StatusNotFound,
+		},
+		{ // 10
+			// Traversal is cleaned up before reaching the route.
+			path:     "/static/../secret",
+			status:   http.StatusMovedPermanently,
+			location: "/secret",
+		},
+		{ // 11
+			method: "POST",
+			path:   "/static/app.js",
+			status: http.StatusNotFound,
+		},
+		{ // 12
+			path:   "/strict/docs/",
+			status: http.StatusOK,
+			body:   "<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n<a href=\"guide/\">guide/</a>\n<a href=\"readme.txt\">readme.txt</a>\n</pre>\n",
+		},
+		{ // 13
+			path:   "/strict/docs",
+			status: http.StatusNotFound,
+		},
+		{ // 14
+			path:    "/strict/app.js",
+			headers: http.Header{"Accept-Encoding": {"gzip"}},
+			status:  http.StatusOK,
+			body:    "console.log('app');",
+		},
+	}
+
+	for i, test := range tests {
+		if test.method == "" {
+			test.method = "GET"
+		}
+		req, _ := http.NewRequest(test.method, "http://example.com"+test.path, nil)
+		for k, v := range test.headers {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("tests[%v]: Expected status '%v', received '%v'.", i, test.status, w.Code)
+			continue
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("tests[%v]: Expected body '%v', received '%v'.", i, test.body, w.Body.String())
+		}
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("tests[%v]: Expected location '%v', received '%v'.", i, test.location, location)
+		}
+		if encoding := w.Header().Get("Content-Encoding"); encoding != test.encoding {
+			t.Errorf("tests[%v]: Expected encoding '%v', received '%v'.", i, test.encoding, encoding)
+		}
+	}
+
+	// Precompressed variants keep the type of the original file.
+	req, _ := http.NewRequest("GET", "http://example.com/static/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if ctype := w.Header().Get("Content-Type"); ctype != "text/javascript; charset=utf-8" {
+		t.Errorf("Expected type 'text/javascript; charset=utf-8', received '%v'.", ctype)
+	}
+	if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Expected vary 'Accept-Encoding', received '%v'.", vary)
+	}
+
+	// Conditional requests use the ETag and modification time.
+	req, _ = http.NewRequest("GET", "http://example.com/static/docs/readme.txt", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	etag := w.Header().Get("Etag")
+	if etag == "" || w.Header().Get("Last-Modified") != modTime.Format(http.TimeFormat) {
+		t.Errorf("Expected an ETag and Last-Modified, received '%v'.", w.Header())
+	}
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected status '304', received '%v'.", w.Code)
+	}
+}
+
+func TestFileServerETag(t *testing.T) {
+	// Files without a modification time are identified by their content.
+	fsys := fstest.MapFS{
+		"a.txt": {Data: []byte("same")},
+		"b.txt": {Data: []byte("same")},
+		"c.txt": {Data: []byte("different")},
+	}
+	server := NewFileServer(fsys)
+	etags := make(map[string]string)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		req, _ := http.NewRequest("GET", "http://example.com/"+name, nil)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		etags[name] = w.Header().Get("Etag")
+		if w.Header().Get("Last-Modified") != "" {
+			t.Errorf("Expected no Last-Modified, received '%v'.", w.Header().Get("Last-Modified"))
+		}
+	}
+	if etags["a.txt"] == "" || etags["a.txt"] != etags["b.txt"] || etags["a.txt"] == etags["c.txt"] {
+		t.Errorf("Expected ETags based on content, received '%v'.", etags)
+	}
+}
+
+func TestRouteServeSPA(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":               {Data: []byte("<div id=\"root\"></div>")},
+		"assets/main.3f2a9c1b.js":  {Data: []byte("hashed")},
+		"assets/vendor.js":         {Data: []byte("unhashed")},
+		"assets/logo.svg":          {Data: []byte("<svg></svg>")},
+		"docs/guide/index.html":    {Data: []byte("guide")},
+		"docs/guide/chapter-1.txt": {Data: []byte("one")},
+	}
+
+	router := NewRouter()
+	router.NewRoute().SetPath("/api/status").SetHandler(func(w http.ResponseWriter, req *Request) {})
+	router.NewRoute().SetMatchSlashes(true).ServeSPA("/", fsys)
+
+	type spaTest struct {
+		method string
+		path   string
+		accept string
+		status int
+		body   string
+		cache  string
+	}
+
+	html := "text/html,application/xhtml+xml,*/*;q=0.8"
+	tests := []spaTest{
+		{"GET", "/", html, http.StatusOK, "<div id=\"root\"></div>", "no-cache"},         // 0
+		{"GET", "/users/42", html, http.StatusOK, "<div id=\"root\"></div>", "no-cache"}, // 1
+		{"HEAD", "/users/42/", html, http.StatusOK, "", "no-cache"},                      // 2
+		{"GET", "/assets/main.3f2a9c1b.js", "*/*", http.StatusOK, "hashed", cacheHashed}, // 3
+		{"GET", "/assets/vendor.js", "*/*", http.StatusOK, "unhashed", ""},               // 4
+		{"GET", "/docs/guide/", html, http.StatusOK, "guide", ""},                        // 5
+		{"GET", "/assets/missing.js", html, http.StatusNotFound, "", ""},                 // 6
+		{"GET", "/api/missing", html, http.StatusNotFound, "", ""},                       // 7
+		{"GET", "/users/42", "application/json", http.StatusNotFound, "", ""},            // 8
+		{"GET", "/users/42", "text/html;q=0, */*", http.StatusNotFound, "", ""},          // 9
+		{"POST", "/users/42", html, http.StatusNotFound, "", ""},                         // 10
+		{"GET", "/api/status", html, http.StatusOK, "", ""},                              // 11
+		{"GET", "/assets/main.3f2a9c1b.js/", "*/*", http.StatusMovedPermanently, "", ""}, // 12
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest(test.method, "http://example.com"+test.path, nil)
+		req.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("tests[%v]: Expected status '%v', received '%v'.", i, test.status, w.Code)
+			continue
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("tests[%v]: Expected body '%v', received '%v'.", i, test.body, w.Body.String())
+		}
+		if cache := w.Header().Get("Cache-Control"); cache != test.cache {
+			t.Errorf("tests[%v]: Expected cache control '%v', received '%v'.", i, test.cache, cache)
+		}
+	}
+
+	// A configured FileServer keeps its own settings.
+	server := NewFileServer(fsys).SetFallback("docs/guide/index.html")
+	router = NewRouter()
+	router.NewRoute().ServeSPA("/app/", server)
+	req, _ := http.NewRequest("GET", "http://example.com/app/api/users", nil)
+	req.Header.Set("Accept", html)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "guide" {
+		t.Errorf("Expected the configured fallback, received '%v' and '%v'.", w.Code, w.Body.String())
+	}
+	if server.HashedAssets() == nil {
+		t.Error("Expected the default hashed asset pattern, received none.")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+// A Group creates routes that share a common configuration, such as a host,
+// path prefix, headers, or middleware, without those routes having to be
+// children of a parent route.
+type Group struct {
+	defaults   *Route
+	parent     *Route // Route that new routes are added to, if any
+	namePrefix string // Name prefix of the enclosing group
+}
+
+// Group calls f with a new Group, whose routes are added to the router.  The
+// group's defaults start out as the router's defaults.
+func (r *Router) Group(f func(g *Group)) *Router {
+	f(&Group{defaults: r.defaultRoute()})
+	return r
+}
+
+// Group calls f with a new Group, whose routes are added as children of the
+// route.  The group's defaults start out as the settings that a child of the
+// route would inherit.  See Route.Subroute.
+func (r *Route) Group(f func(g *Group)) *Route {
+	f(&Group{
+		defaults:   r.inherit(),
+		parent:     r,
+		namePrefix: r.namePrefix,
+	})
+	return r
+}
+
+// Defaults returns the route whose settings are used as the defaults of
+// routes created by the group.  Any setting that a child route inherits from
+// its parent can be changed, including the path, which is used as the base
+// of any path set on the group's routes, and is matched by routes that do not
+// set a path of their own.  Changes only apply to routes created after they
+// are made.  Any error set on the defaults route is also set on the routes
+// that the group creates.  See Route.Subroute.
+//
+// The defaults route never matches a request, and should not be named or
+// given a handler.
+func (g *Group) Defaults() *Route {
+	return g.defaults
+}
+
+// SetNamePrefix sets a prefix, such as "admin.", that is prepended to the
+// names of routes created by the group.  The prefix is added to that of any
+// enclosing group.
+func (g *Group) SetNamePrefix(p string) *Group {
+	g.defaults.namePrefix = g.namePrefix + p
+	return g
+}
+
+// NamePrefix returns the prefix that is prepended to the names of routes
+// created by the group, including that of any enclosing group.
+func (g *Group) NamePrefix() string {
+	return g.defaults.namePrefix
+}
+
+// UnsetNamePrefix clears the group's name prefix.  The prefix of any
+// enclosing group still applies.
+func (g *Group) UnsetNamePrefix() {
+	g.defaults.namePrefix = g.namePrefix
+}
+
+// NewRoute creates a new Route using the group's defaults.
+func (g *Group) NewRoute() *Route {
+	route := g.inherit()
+	if g.parent != nil {
+		g.parent.children = append(g.parent.children, route)
+	} else {
+		route.router.routes = append(route.router.routes, route)
+	}
+	return route
+}
+
+// Group calls f with a new Group, nested within g.  The new group's defaults
+// start out as g's defaults, and its name prefix is added to g's.
+func (g *Group) Group(f func(g *Group)) *Group {
+	f(&Group{
+		defaults:   g.inherit(),
+		parent:     g.parent,
+		namePrefix: g.defaults.namePrefix,
+	})
+	return g
+}
+
+// inherit returns a new Route with the group's defaults.  The new route is
+// not added to the router.
+func (g *Group) inherit() *Route {
+	route := g.defaults.inherit()
+	if g.defaults.path == nil {
+		route.parentPath = g.defaults.parentPath
+	} else {
+		route.path = g.defaults.path
+	}
+	if g.defaults.middleware != nil {
+		// The defaults route is not part of the matched chain, so its
+		// middleware is copied.
+		route.middleware = append([]Middleware(nil), g.defaults.middleware...)
+	}
+	route.err = g.defaults.err
+	return route
+}
@@ -0,0 +1,99 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRouterGroup(t *testing.T) {
+	var calls []string
+	handler := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, req *Request) {
+			calls = append(calls, name+":"+req.Params["id"])
+		}
+	}
+	middleware := func(name string) Middleware {
+		return func(h HandlerFunc) HandlerFunc {
+			return func(w http.ResponseWriter, req *Request) {
+				calls = append(calls, name)
+				h(w, req)
+			}
+		}
+	}
+
+	router := NewRouter()
+	router.Group(func(g *Group) {
+		g.SetNamePrefix("admin.")
+		g.Defaults().SetHost("admin.example.com").SetPrefix("/admin").SetHeader("X-Admin", "1").SetMiddleware(middleware("admin"))
+		g.NewRoute().SetName("user").SetPath("/users/{id}").SetMethods("GET").SetHandler(handler("user"))
+		g.Group(func(g *Group) {
+			g.SetNamePrefix("reports.")
+			g.Defaults().SetPrefix("/reports").SetMiddleware(append(g.Defaults().Middleware(), middleware("reports"))...)
+			g.NewRoute().SetName("show").SetPath("/{id}").SetHandler(handler("report"))
+		})
+		// Routes without a path of their own match the group's path.
+		g.NewRoute().SetName("home").SetHandler(handler("home"))
+	})
+	api := router.NewRoute().SetPrefix("/api/").SetName("api")
+	api.Group(func(g *Group) {
+		g.Defaults().SetMethods("POST")
+		g.NewRoute().SetPath("/items").SetHandler(handler("items"))
+	})
+	// Errors set on the defaults are set on the group's routes.
+	var bad *Route
+	router.Group(func(g *Group) {
+		g.Defaults().SetPath("{")
+		bad = g.NewRoute()
+	})
+	if bad.Error() == nil {
+		t.Error("Expected an error on the group's route, received none.")
+	}
+
+	type groupTest struct {
+		method string
+		host   string
+		path   string
+		header bool
+		calls  []string
+	}
+
+	tests := []groupTest{
+		{"GET", "admin.example.com", "/admin/users/1", true, []string{"admin", "user:1"}},                // 0
+		{"GET", "admin.example.com", "/admin/reports/2", true, []string{"admin", "reports", "report:2"}}, // 1
+		{"GET", "admin.example.com", "/admin/users/1", false, nil},                                       // 2
+		{"GET", "example.com", "/admin/users/1", true, nil},                                              // 3
+		{"GET", "admin.example.com", "/admin/anything", true, []string{"admin", "home:"}},                // 4
+		{"POST", "example.com", "/api/items", false, []string{"items:"}},                                 // 5
+		{"GET", "example.com", "/api/items", false, nil},                                                 // 6
+	}
+
+	for i, test := range tests {
+		calls = nil
+		req, _ := http.NewRequest(test.method, "http://"+test.host+test.path, nil)
+		if test.header {
+			req.Header.Set("X-Admin", "1")
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		if !reflect.DeepEqual(calls, test.calls) {
+			t.Errorf("tests[%v]: Expected calls '%v', received '%v'.", i, test.calls, calls)
+		}
+	}
+
+	names := map[string]string{
+		"admin.home":         "/admin",
+		"admin.user":         "/admin/users/{id}",
+		"admin.reports.show": "/admin/reports/{id}",
+		"api":                "/api/",
+	}
+	for name, path := range names {
+		if route, err := router.Route(name); err != nil || route.Path() != path {
+			t.Errorf("Expected route '%v' with path '%v', received '%v' and error '%v'.", name, path, route, err)
+		}
+	}
+}
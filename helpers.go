@@ -5,11 +5,14 @@
 package routing
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
+	"net"
 	"net/http"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -21,44 +24,89 @@ const (
 	errRouteNotDefined      = "routing: Route '%s' is not defined."
 	errPathIsInvalid        = "routing: '%s' is not a valid path."
 	errUnexpectedParamCount = "routing: Expected %d params, received %d."
+	errMissingParam         = "routing: Parameter '%s' is missing."
+	errInvalidParamValue    = "routing: '%s' is not a valid value for parameter '%s'."
+	errInvalidProxy         = "routing: '%s' is not a valid IP address or CIDR."
+	errInvalidMethod        = "routing: '%s' is not a valid method name."
+	errMethodNotDefined     = "routing: Method '%s' is not defined."
 )
 
 // Error messages related to host and path parsing.
 const (
 	errEmptyHost           = "routing: Host can not be empty."
+	errInvalidHost         = "routing: '%s' is not a valid host."
 	errEmptyPath           = "routing: Path can not be empty."
 	errUnevenBraces        = "routing: Uneven number of braces."
 	errParamNameDefined    = "routing: Parameter '%s' has already been defined."
 	errParamNameNotDefined = "routing: Parameter name can not be empty."
+	errCatchAllNotLast     = "routing: Catch-all parameter '%s' must be at the end of the path."
 )
 
 // hostInfo holds all of the components of a valid parsed host.
 type hostInfo struct {
-	rawHost string
-	pattern *regexp.Regexp
+	rawHost     string
+	urlHost     string // Host used when building URLs, if it can be built
+	pattern     *regexp.Regexp
+	portPattern *regexp.Regexp
 }
 
 // pathInfo holds all of the components of a valid parsed path.
 type pathInfo struct {
 	rawPath    string
 	fwdPattern *regexp.Regexp
-	revPattern string // FIXME: This isn't actually used yet.
+	revPattern string
 	params     [][]string
+	validators []*regexp.Regexp // Match the whole value of each parameter
 }
 
-// The list of valid HTTP request methods.
-var validMethods = map[string]bool{
+// paramSpans returns the start and end of each parameter of p within path, or
+// nil if path does not match.  Parameters that did not participate in the
+// match have a start and end of -1.
+func (p *pathInfo) paramSpans(path string) [][2]int {
+	paramIndex := p.fwdPattern.FindStringSubmatchIndex(path)
+	if paramIndex == nil {
+		return nil
+	}
+
+	// paramIndex[i] is where the param starts, and paramIndex[i+1] is where it ends.
+	// Skip the first pair, since that is just [startOfString, endOfString].
+	spans := [][2]int{}
+	for i, j := 2, 2; i < len(paramIndex); i += j - i {
+		for j += 2; j < len(paramIndex); j += 2 {
+			// Skip over all parameters that start and end between [i] and [i+1].
+			if paramIndex[i+1] <= paramIndex[j] {
+				break
+			}
+		}
+		spans = append(spans, [2]int{paramIndex[i], paramIndex[i+1]})
+	}
+	return spans
+}
+
+// The list of HTTP request methods known to every router.
+var defaultMethods = []Method{
 	// The following methods are defined in RFC 2616:
-	"OPTIONS": true,
-	"GET":     true,
-	"HEAD":    true,
-	"POST":    true,
-	"PUT":     true,
-	"DELETE":  true,
-	"TRACE":   true,
-	"CONNECT": true,
+	{"OPTIONS", true, true},
+	{"GET", true, true},
+	{"HEAD", true, true},
+	{"POST", false, false},
+	{"PUT", false, true},
+	{"DELETE", false, true},
+	{"TRACE", true, true},
+	{"CONNECT", false, false},
 	// The following methods are defined in RFC 5789:
-	"PATCH": true,
+	{"PATCH", false, false},
+}
+
+// The list of HTTP request methods defined by WebDAV, in RFC 4918.
+var webDAVMethods = []Method{
+	{"PROPFIND", true, true},
+	{"PROPPATCH", false, true},
+	{"MKCOL", false, true},
+	{"COPY", false, true},
+	{"MOVE", false, true},
+	{"LOCK", false, false},
+	{"UNLOCK", false, true},
 }
 
 // Return the canonical path for p, eliminating . and .. elements.
@@ -82,27 +130,184 @@ func cleanPath(p string) string {
 // match attempts to find a route that matches the given request.  Routes
 // are evaluated in the order that they were created by NewRoute().
 func match(req *http.Request, routes []*Route) *Route {
+	var fallback *Route
 	for _, route := range routes {
 		if !route.matchSchemes(req) ||
 			!route.matchMethods(req) ||
 			!route.matchHeaders(req) ||
+			!route.matchWebSocket(req) ||
 			!route.matchHost(req) ||
 			!route.matchPath(req) {
 			continue
 		}
+		if !route.matchVersion(req) {
+			// Remember the route serving the latest compatible version, in
+			// case no route serves the requested version.
+			if route.betterVersionFallback(req, fallback) {
+				fallback = route
+			}
+			continue
+		}
 		return route
 	}
-	return nil
+	return fallback
+}
+
+// allowedMethods returns the methods of the routes in routes, or their
+// children, that would match the request if not for its method.
+func allowedMethods(req *http.Request, routes []*Route) []string {
+	allowed := make(map[string]bool)
+	collectAllowedMethods(req, routes, allowed)
+	m := make([]string, 0, len(allowed))
+	for k := range allowed {
+		m = append(m, k)
+	}
+	sort.Strings(m)
+	return m
+}
+
+// collectAllowedMethods adds the methods found by allowedMethods to allowed.
+func collectAllowedMethods(req *http.Request, routes []*Route, allowed map[string]bool) {
+	for _, route := range routes {
+		if !route.matchSchemes(req) ||
+			!route.matchHeaders(req) ||
+			!route.matchWebSocket(req) ||
+			!route.matchHost(req) ||
+			!route.matchPath(req) {
+			continue
+		}
+		if route.matchMethods(req) {
+			collectAllowedMethods(req, route.children, allowed)
+			continue
+		}
+		for k := range route.methods {
+			allowed[k] = true
+		}
+	}
+}
+
+// matchChain attempts to find a route that matches the given request, then
+// does the same for that route's children, and so on.  The matched routes
+// are returned in order from parent to child.
+func matchChain(req *http.Request, routes []*Route) []*Route {
+	var chain []*Route
+	for route := match(req, routes); route != nil; route = match(req, route.children) {
+		chain = append(chain, route)
+	}
+	return chain
 }
 
 // parseHost attempts to parse the provided host into a regular expression
-// that can be used when matching routes.
+// that can be used when matching routes.  If the host is followed by a port,
+// the port is parsed into a separate regular expression.
 func parseHost(host string) (*hostInfo, error) {
 	// Empty hosts are not valid.
 	if host == "" {
 		return nil, fmt.Errorf(errEmptyHost)
 	}
 
+	name, port, err := splitHostPattern(host)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(name, "{") {
+		name = normalizeHostName(name)
+	} else {
+		name = strings.TrimSuffix(name, ".")
+	}
+	re, err := compileHostPattern(name, "[^.]+", true)
+	if err != nil {
+		return nil, err
+	}
+	info := &hostInfo{
+		rawHost: host,
+		pattern: re,
+	}
+	if port != "" {
+		if info.portPattern, err = compileHostPattern(port, "[0-9]+", false); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.Contains(host, "{") {
+		info.urlHost = name
+		if strings.Contains(name, ":") {
+			info.urlHost = "[" + name + "]"
+		}
+		if port != "" {
+			info.urlHost += ":" + port
+		}
+	}
+	return info, nil
+}
+
+// splitHostPattern splits a host pattern into its host name and port.  Colons
+// within parameters are ignored, and a host containing more than one colon
+// is assumed to be an IPv6 address without a port.
+func splitHostPattern(host string) (string, string, error) {
+	if host[0] == '[' {
+		end := strings.IndexByte(host, ']')
+		if end < 0 {
+			return "", "", fmt.Errorf(errInvalidHost, host)
+		}
+		switch rest := host[end+1:]; {
+		case rest == "":
+			return host[1:end], "", nil
+		case len(rest) > 1 && rest[0] == ':':
+			return host[1:end], rest[1:], nil
+		}
+		return "", "", fmt.Errorf(errInvalidHost, host)
+	}
+
+	var depth int
+	colons := make([]int, 0, 1)
+	for i := range host {
+		switch host[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				colons = append(colons, i)
+			}
+		}
+	}
+	if len(colons) != 1 {
+		return host, "", nil
+	}
+	if colons[0] == len(host)-1 {
+		return "", "", fmt.Errorf(errInvalidHost, host)
+	}
+	return host[:colons[0]], host[colons[0]+1:], nil
+}
+
+// hostParamRegexp matches a named host parameter, such as "sub:[a-z]+".
+var hostParamRegexp = regexp.MustCompile("^([A-Za-z_][A-Za-z0-9_]*):(.*)$")
+
+// compileHostPattern compiles a host name or port pattern into a regular
+// expression.  Named parameters become named groups, and use defaultPattern
+// if they do not specify one.  If isName is true, the parts of the pattern
+// outside of parameters are lower cased, and converted to punycode if they
+// contain non-ASCII characters.
+func compileHostPattern(host, defaultPattern string, isName bool) (*regexp.Regexp, error) {
+	// literal quotes the literal text s.  Since only whole labels can be
+	// converted to punycode, partial labels must already be ASCII.
+	literal := func(s string, startsLabel, endsLabel bool) (string, error) {
+		if isName && !isASCII(s) {
+			labels := strings.Split(s, ".")
+			if (!startsLabel && !isASCII(labels[0])) || (!endsLabel && !isASCII(labels[len(labels)-1])) {
+				return "", fmt.Errorf(errInvalidHost, host)
+			}
+			var err error
+			if s, err = hostToASCII(s); err != nil {
+				return "", fmt.Errorf(errInvalidHost, host)
+			}
+		} else if isName {
+			s = strings.ToLower(s)
+		}
+		return regexp.QuoteMeta(s), nil
+	}
+
 	pattern := bytes.NewBufferString("^")
 	var depth, param, pos int
 	for i := range host {
@@ -113,7 +318,18 @@ func parseHost(host string) (*hostInfo, error) {
 			}
 		case '}':
 			if depth--; depth == 0 {
-				fmt.Fprintf(pattern, "%s(%s)", regexp.QuoteMeta(host[pos:param]), host[param+1:i])
+				prefix, err := literal(host[pos:param], pos == 0, false)
+				if err != nil {
+					return nil, err
+				}
+				if nameVal := hostParamRegexp.FindStringSubmatch(host[param+1 : i]); nameVal != nil {
+					if nameVal[2] == "" {
+						nameVal[2] = defaultPattern
+					}
+					fmt.Fprintf(pattern, "%s(?P<%s>%s)", prefix, nameVal[1], nameVal[2])
+				} else {
+					fmt.Fprintf(pattern, "%s(%s)", prefix, host[param+1:i])
+				}
 				pos = i + 1
 			} else if depth < 0 {
 				// With properly formatted input, depth should never go below zero.
@@ -127,19 +343,68 @@ func parseHost(host string) (*hostInfo, error) {
 	}
 
 	if pos < len(host) {
-		fmt.Fprint(pattern, regexp.QuoteMeta(host[pos:]))
+		suffix, err := literal(host[pos:], pos == 0, true)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprint(pattern, suffix)
 	}
 	pattern.WriteByte('$')
 
-	re, err := regexp.Compile(pattern.String())
+	return regexp.Compile(pattern.String())
+}
+
+// splitRequestHost splits the host of a request into its normalized host
+// name and its port.  The port is empty if the request did not include one.
+func splitRequestHost(host string) (string, string) {
+	name, port, err := net.SplitHostPort(host)
 	if err != nil {
-		return nil, err
+		name, port = host, ""
 	}
+	return normalizeHostName(name), port
+}
 
-	return &hostInfo{
-		rawHost: host,
-		pattern: re,
-	}, nil
+// normalizeHostName lower cases a host name, and removes any brackets or
+// trailing dot.  IPv6 addresses are converted to their canonical form, and
+// internationalized domain names are converted to punycode, after being
+// mapped and normalized as described by UTS #46.
+func normalizeHostName(name string) string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		name = name[1 : len(name)-1]
+	}
+	if strings.Contains(name, ":") {
+		if ip := net.ParseIP(name); ip != nil {
+			name = ip.String()
+		}
+	} else if !isASCII(name) {
+		if ascii, err := hostToASCII(name); err == nil {
+			name = ascii
+		}
+	}
+	return name
+}
+
+// addNamedSubmatches adds the values of the named groups in re, as matched
+// against s, to params.
+func addNamedSubmatches(params map[string]string, re *regexp.Regexp, s string) {
+	m := re.FindStringSubmatch(s)
+	if m == nil {
+		return
+	}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			params[name] = m[i]
+		}
+	}
+}
+
+// defaultPort returns the port used by scheme when none is given.
+func defaultPort(scheme string) string {
+	if scheme == "https" {
+		return "443"
+	}
+	return "80"
 }
 
 // parsePath attempts to parse the provided path into a regular expression
@@ -178,14 +443,21 @@ func parsePath(path string, matchPrefix, matchSlashes bool) (*pathInfo, error) {
 				}
 
 				if len(nameVal) < 2 {
-					nameVal[1] = ""
+					nameVal = append(nameVal, "")
 				}
-				if nameVal[1] == "" {
+				switch nameVal[1] {
+				case "":
 					nameVal[1] = "[^/]+"
+				case "*":
+					// Catch-all parameters match the rest of the path.
+					if i != len(path)-1 {
+						return nil, fmt.Errorf(errCatchAllNotLast, nameVal[0])
+					}
+					nameVal[1] = ".*"
 				}
 				subPath := path[pos:param]
 				fmt.Fprintf(fwdPattern, "%s(%s)", regexp.QuoteMeta(subPath), nameVal[1])
-				fmt.Fprintf(revPattern, "%s%%s", subPath)
+				fmt.Fprintf(revPattern, "%s%%s", strings.Replace(subPath, "%", "%%", -1))
 				params = append(params, nameVal)
 				pos = i + 1
 			} else if depth < 0 {
@@ -201,7 +473,7 @@ func parsePath(path string, matchPrefix, matchSlashes bool) (*pathInfo, error) {
 
 	if pos < len(path) {
 		fmt.Fprint(fwdPattern, regexp.QuoteMeta(path[pos:]))
-		fmt.Fprint(revPattern, path[pos:])
+		fmt.Fprint(revPattern, strings.Replace(path[pos:], "%", "%%", -1))
 	}
 
 	if path != "/" && matchSlashes {
@@ -218,15 +490,40 @@ func parsePath(path string, matchPrefix, matchSlashes bool) (*pathInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	validators := make([]*regexp.Regexp, len(params))
+	for i, param := range params {
+		if validators[i], err = regexp.Compile("^(?:" + param[1] + ")$"); err != nil {
+			return nil, err
+		}
+	}
 
 	return &pathInfo{
 		rawPath:    path,
 		fwdPattern: fwdRegexp,
 		revPattern: revPattern.String(),
 		params:     params,
+		validators: validators,
 	}, nil
 }
 
+// build fills in the path's parameters using the values in params, returning
+// the resulting path.  Every parameter must be present, and its value must
+// match the parameter's pattern.
+func (p *pathInfo) build(params map[string]string) (string, error) {
+	values := make([]interface{}, len(p.params))
+	for i, param := range p.params {
+		v, ok := params[param[0]]
+		if !ok {
+			return "", fmt.Errorf(errMissingParam, param[0])
+		}
+		if !p.validators[i].MatchString(v) {
+			return "", fmt.Errorf(errInvalidParamValue, v, param[0])
+		}
+		values[i] = v
+	}
+	return fmt.Sprintf(p.revPattern, values...), nil
+}
+
 // sliceContainsString checks to see if a string exists within a slice of
 // strings.
 func sliceContainsString(s []string, v string) bool {
@@ -250,16 +547,16 @@ func sliceContainsStrings(s, v []string) bool {
 }
 
 // validateMethods takes a list of methods and verifies that they are
-// supported.  It returns a properly formatted map on success, or an error if
+// registered.  It returns a properly formatted map on success, or an error if
 // an unsupported method was provided.
-func validateMethods(m ...string) (map[string]bool, error) {
+func validateMethods(registered map[string]Method, m ...string) (map[string]bool, error) {
 	if len(m) == 0 {
 		return nil, fmt.Errorf(errUnsupportedMethod, "")
 	}
 	methods := make(map[string]bool)
 	for _, v := range m {
 		v = strings.ToUpper(v)
-		if !validMethods[v] {
+		if _, ok := registered[v]; !ok {
 			return nil, fmt.Errorf(errUnsupportedMethod, v)
 		}
 		methods[v] = true
@@ -267,6 +564,22 @@ func validateMethods(m ...string) (map[string]bool, error) {
 	return methods, nil
 }
 
+// validateToken verifies that s is a token, as defined by RFC 7230.
+func validateToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') ||
+			strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0 {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
 // validateSchemes takes a list of schemes and verifies that they are
 // supported.  It returns a properly formatted map on success, or an error if
 // an unsupported scheme was provided.
@@ -277,10 +590,59 @@ func validateSchemes(s ...string) (map[string]bool, error) {
 	schemes := make(map[string]bool)
 	for _, v := range s {
 		v = strings.ToLower(v)
-		if v != "http" && v != "https" {
+		if v != "http" && v != "https" && v != "ws" && v != "wss" {
 			return nil, fmt.Errorf(errUnsupportedScheme, v)
 		}
 		schemes[v] = true
 	}
 	return schemes, nil
 }
+
+// statusWriter is a http.ResponseWriter that records the status code and
+// size of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader records the status code, then sends the response header.
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+// Write records a status code of 200 if none has been sent, then writes p to
+// the response.
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(p)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+// Flush sends any buffered data to the client, if the underlying writer
+// supports it.
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection, if the underlying writer
+// supports it.
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := sw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap returns the underlying writer, for use by http.ResponseController.
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
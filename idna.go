@@ -0,0 +1,56 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// acePrefix is the prefix given to labels that have been encoded with
+// punycode.
+const acePrefix = "xn--"
+
+// UnicodeHost returns the host name that the client made the request to, with
+// any internationalized labels converted from punycode to Unicode.  The port
+// is not included.
+func (r *Request) UnicodeHost() string {
+	name, _ := splitRequestHost(r.Host())
+	return hostToUnicode(name)
+}
+
+// hostToASCII converts a host name to its ASCII form, as it is looked up in
+// DNS.  The name is mapped and normalized as described by UTS #46, so that
+// case, width, and composition differences do not matter, then each label
+// that contains non-ASCII characters is converted to punycode.
+func hostToASCII(host string) (string, error) {
+	return idna.Lookup.ToASCII(host)
+}
+
+// hostToUnicode converts each punycode label of a host name to Unicode.
+// Labels that fail to decode are left alone.
+func hostToUnicode(host string) string {
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if len(label) > len(acePrefix) && strings.EqualFold(label[:len(acePrefix)], acePrefix) {
+			if decoded, err := idna.Lookup.ToUnicode(label); err == nil {
+				labels[i] = decoded
+			}
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// isASCII returns true if s only contains ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteIDN(t *testing.T) {
+	type idnTest struct {
+		routeHost   string
+		requestHost string
+		matched     bool
+	}
+
+	tests := []idnTest{
+		{"bücher.example", "xn--bcher-kva.example", true},
+		{"bücher.example", "bücher.example:8080", true},
+		{"BÜCHER.example", "XN--BCHER-KVA.example", true},
+		{"xn--bcher-kva.example", "bücher.example", true},
+		{"{sub:[a-z]+}.bücher.example", "www.xn--bcher-kva.example", true},
+		{"bücher.example", "bu\u0308cher.example", true},
+		{"bu\u0308cher.example", "xn--bcher-kva.example", true},
+		{"ｂüｃｈｅｒ．example", "xn--bcher-kva.example", true},
+		{"example.com", "ｅｘａｍｐｌｅ.com", true},
+		{"straße.de", "STRAẞE.de", true},
+		{"straße.de", "xn--strae-oqa.de", true},
+		{"straße.de", "strasse.de", false},
+		{"bücher.example", "buecher.example", false},
+		{"münchen.example", "xn--bcher-kva.example", false},
+	}
+	router := NewRouter()
+	route := router.NewRoute()
+
+	for pos, v := range tests {
+		route.UnsetError()
+		route.SetHost(v.routeHost)
+		if route.Error() != nil {
+			t.Errorf("tests[%v]: Expected no error, received '%v'.", pos, route.Error())
+			continue
+		}
+		request, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatalf("tests[%v]: Expected no error, received '%v'.", pos, err)
+		}
+		request.Host = v.requestHost
+		if matched := route.matchHost(request); matched != v.matched {
+			t.Errorf("tests[%v]: Expected host '%v' matching '%v' to be %v, received %v.", pos, v.routeHost, v.requestHost, v.matched, matched)
+		}
+	}
+
+	// Non-ASCII labels can not be split by a parameter.
+	route.UnsetError()
+	route.SetHost("{sub:[a-z]+}ü.example")
+	if route.Error() == nil {
+		t.Error("Expected an error, received none.")
+	}
+}
+
+func TestRouteIDN_url(t *testing.T) {
+	var unicodeHost string
+	router := NewRouter()
+	route := router.NewRoute().SetHost("Bücher.example:8080").SetPath("/").
+		SetHandler(func(w http.ResponseWriter, r *Request) {
+			unicodeHost = r.UnicodeHost()
+		})
+
+	// Absolute URLs use punycode.
+	u, err := route.URL(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	if u.String() != "http://xn--bcher-kva.example:8080/" {
+		t.Errorf("Expected URL 'http://xn--bcher-kva.example:8080/', received '%v'.", u)
+	}
+
+	// The Unicode host is available to handlers.
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://xn--bcher-kva.example:8080/", nil))
+	if unicodeHost != "bücher.example" {
+		t.Errorf("Expected Unicode host 'bücher.example', received '%v'.", unicodeHost)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Errors returned by Router.Match.
+var (
+	// ErrNotFound is returned when no route matches the request.
+	ErrNotFound = errors.New("routing: No route matches the request.")
+	// ErrMethodNotAllowed is returned when no route matches the request, but
+	// some would if not for the request's method.
+	ErrMethodNotAllowed = errors.New("routing: Method is not allowed.")
+)
+
+// A RouteMatch describes how a router would handle a request.
+type RouteMatch struct {
+	// Route is the last matched route, which determines how the request is
+	// handled.
+	Route *Route
+	// Chain holds every matched route, in order from parent to child.
+	Chain []*Route
+	// Params holds the parameters seen by Route.
+	Params map[string]string
+	// Redirect is the location that the request would be redirected to,
+	// either to clean up its path or its trailing slash.  No handler is
+	// called for a request that is redirected.
+	Redirect string
+	// RedirectCode is the status code of the redirect, if any.
+	RedirectCode int
+	// Allowed holds the methods that would match the request if
+	// ErrMethodNotAllowed is returned.
+	Allowed []string
+
+	chainParams []map[string]string // Parameters seen by each route in Chain
+}
+
+// Match resolves req against the router's routes in the same way as
+// ServeHTTP, but without calling any handlers.  Trusted proxy headers, method
+// overrides, and API versions are taken into account.  The body of req is
+// left unread, so that it can still be passed on, which means that method
+// overrides are only read from headers, and not from form fields.
+//
+// If no route matches, ErrNotFound or ErrMethodNotAllowed is returned.  In
+// the latter case, the returned RouteMatch holds the allowed methods.  If the
+// last matched route has children, none of which matched, and no handler of
+// its own, the returned RouteMatch holds the matched chain along with one of
+// those errors, since the request would be handled by a not found or method
+// not allowed handler once the chain's handlers had run.
+//
+// Any other error means that the parameters of a matched route could not be
+// read, and that ServeHTTP would respond with 500 Internal Server Error.
+//
+// Requests to a mounted router are not resolved further than the mount
+// point.
+func (r *Router) Match(req *http.Request) (*RouteMatch, error) {
+	req = r.forwarded(req)
+	if location := cleanPathRedirect(req); location != "" {
+		return &RouteMatch{
+			Redirect:     location,
+			RedirectCode: http.StatusMovedPermanently,
+		}, nil
+	}
+	req = r.versioned(r.overrideMethod(req, false))
+
+	m, err := r.match(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case m.Route == nil && len(m.Allowed) > 0:
+		return m, ErrMethodNotAllowed
+	case m.Route == nil:
+		return nil, ErrNotFound
+	case m.Redirect == "" && len(m.Route.children) > 0 && m.Route.handler == nil:
+		if m.Allowed = allowedMethods(req, m.Route.children); len(m.Allowed) > 0 {
+			return m, ErrMethodNotAllowed
+		}
+		return m, ErrNotFound
+	}
+	return m, nil
+}
+
+// match finds the routes that match req.  If none do, the returned
+// RouteMatch has a nil Route, and holds any methods that would match.  An
+// error is returned if the parameters of the matched routes can not be read.
+func (r *Router) match(req *http.Request) (*RouteMatch, error) {
+	chain := matchChain(req, r.routes)
+	if len(chain) == 0 {
+		return &RouteMatch{
+			Allowed: allowedMethods(req, r.routes),
+		}, nil
+	}
+
+	route := chain[len(chain)-1]
+	params, err := chainParams(req, chain)
+	if err != nil {
+		return nil, err
+	}
+	m := &RouteMatch{
+		Route:       route,
+		Chain:       chain,
+		Params:      params[len(params)-1],
+		chainParams: params,
+	}
+
+	// Redirect to clean up trailing slashes if needed.
+	if route.path != nil && route.matchSlashes {
+		if strings.HasSuffix(route.path.rawPath, "/") && !strings.HasSuffix(req.URL.Path, "/") {
+			m.Redirect = mountPrefix(req) + req.URL.Path + "/"
+		} else if !strings.HasSuffix(route.path.rawPath, "/") && strings.HasSuffix(req.URL.Path, "/") {
+			m.Redirect = mountPrefix(req) + req.URL.Path[:len(req.URL.Path)-1]
+		}
+		if m.Redirect != "" {
+			m.RedirectCode = http.StatusMovedPermanently
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRouterMatch(t *testing.T) {
+	called := false
+	handler := func(w http.ResponseWriter, req *Request) {
+		called = true
+	}
+	router := NewRouter().SetMethodOverride("DELETE")
+	users := router.NewRoute().SetName("users").SetPrefix("/users/")
+	user := users.Subroute().SetName("user").SetPath("/{id:[0-9]+}").SetMethods("GET", "DELETE").SetHandler(handler)
+	docs := router.NewRoute().SetMatchSlashes(true).SetPath("/docs/").SetHandler(handler)
+	router.NewRoute().SetPath("/upload").SetMethods("PUT").SetHandler(handler)
+
+	type matchTest struct {
+		method   string
+		path     string
+		headers  http.Header
+		err      error
+		chain    []*Route
+		params   map[string]string
+		redirect string
+		allowed  []string
+	}
+
+	tests := []matchTest{
+		{ // 0
+			method: "GET",
+			path:   "/users/42",
+			chain:  []*Route{users, user},
+			params: map[string]string{"id": "42"},
+		},
+		{ // 1
+			// Method overrides are applied.
+			method:  "POST",
+			path:    "/users/42",
+			headers: http.Header{"X-Http-Method-Override": {"DELETE"}},
+			chain:   []*Route{users, user},
+			params:  map[string]string{"id": "42"},
+		},
+		{ // 2
+			// The parent matches, but none of its children do.
+			method:  "PUT",
+			path:    "/users/42",
+			err:     ErrMethodNotAllowed,
+			chain:   []*Route{users},
+			params:  map[string]string{},
+			allowed: []string{"DELETE", "GET"},
+		},
+		{ // 3
+			method:  "GET",
+			path:    "/upload",
+			err:     ErrMethodNotAllowed,
+			allowed: []string{"PUT"},
+		},
+		{ // 4
+			method: "GET",
+			path:   "/missing",
+			err:    ErrNotFound,
+		},
+		{ // 5
+			method:   "GET",
+			path:     "/docs",
+			chain:    []*Route{docs},
+			params:   map[string]string{},
+			redirect: "/docs/",
+		},
+		{ // 6
+			method:   "GET",
+			path:     "/users/../docs/",
+			redirect: "/docs/",
+		},
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest(test.method, "http://example.com"+test.path, nil)
+		for k, v := range test.headers {
+			req.Header[k] = v
+		}
+		m, err := router.Match(req)
+		if err != test.err {
+			t.Errorf("tests[%v]: Expected error '%v', received '%v'.", i, test.err, err)
+		}
+		if m == nil {
+			if test.chain != nil || test.redirect != "" || test.allowed != nil {
+				t.Errorf("tests[%v]: Expected a match, received none.", i)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(m.Chain, test.chain) {
+			t.Errorf("tests[%v]: Expected chain '%v', received '%v'.", i, test.chain, m.Chain)
+		}
+		if len(test.chain) > 0 && m.Route != test.chain[len(test.chain)-1] {
+			t.Errorf("tests[%v]: Expected the last route in the chain, received '%v'.", i, m.Route)
+		}
+		if !reflect.DeepEqual(m.Params, test.params) {
+			t.Errorf("tests[%v]: Expected params '%v', received '%v'.", i, test.params, m.Params)
+		}
+		if m.Redirect != test.redirect {
+			t.Errorf("tests[%v]: Expected redirect '%v', received '%v'.", i, test.redirect, m.Redirect)
+		}
+		if test.redirect != "" && m.RedirectCode != http.StatusMovedPermanently {
+			t.Errorf("tests[%v]: Expected code '301', received '%v'.", i, m.RedirectCode)
+		}
+		if !reflect.DeepEqual(m.Allowed, test.allowed) {
+			t.Errorf("tests[%v]: Expected allowed '%v', received '%v'.", i, test.allowed, m.Allowed)
+		}
+	}
+
+	// Form fields are not read, so the body is left for the caller.
+	body := "_method=DELETE"
+	req, _ := http.NewRequest("POST", "http://example.com/users/42", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := router.Match(req); err != ErrMethodNotAllowed {
+		t.Errorf("Expected error '%v', received '%v'.", ErrMethodNotAllowed, err)
+	}
+	if b, _ := io.ReadAll(req.Body); string(b) != body {
+		t.Errorf("Expected body '%v', received '%s'.", body, b)
+	}
+
+	if called {
+		t.Error("Expected no handlers to be called.")
+	}
+}
+
+func TestRouterMatch_paramError(t *testing.T) {
+	called := false
+	router := NewRouter()
+	route := router.NewRoute().SetPath("/users/{id}").SetHandler(func(w http.ResponseWriter, req *Request) {
+		called = true
+	})
+	// A path whose parameters do not line up with its pattern can not have
+	// its parameters read.
+	route.path.params = append(route.path.params, []string{"extra", ""})
+
+	req, _ := http.NewRequest("GET", "http://example.com/users/42", nil)
+	if m, err := router.Match(req); err == nil || m != nil {
+		t.Errorf("Expected an error and no match, received '%v' and '%v'.", err, m)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status '500', received '%v'.", w.Code)
+	}
+	if called {
+		t.Error("Expected the handler not to be called.")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Method describes a HTTP request method that routes can match.
+type Method struct {
+	Name       string
+	Safe       bool // The method does not change state on the server
+	Idempotent bool // Repeating the request has the same effect as sending it once
+}
+
+// RegisterMethod registers a HTTP request method with the router, allowing
+// routes to match it.  Method names must be valid tokens as defined by RFC
+// 7230, and are not case sensitive.  Registering a method that already exists
+// replaces its properties.  If the name is invalid, the method is not
+// registered, and an error message is set on the router.
+//
+// The methods defined by RFC 2616 and RFC 5789 are registered by default.
+func (r *Router) RegisterMethod(name string, safe, idempotent bool) *Router {
+	if !validateToken(name) {
+		r.err = fmt.Errorf(errInvalidMethod, name)
+		return r
+	}
+	name = strings.ToUpper(name)
+	r.methods[name] = Method{
+		Name:       name,
+		Safe:       safe,
+		Idempotent: idempotent,
+	}
+	return r
+}
+
+// RegisterWebDAVMethods registers the methods defined by WebDAV, in RFC 4918.
+func (r *Router) RegisterWebDAVMethods() *Router {
+	for _, m := range webDAVMethods {
+		r.methods[m.Name] = m
+	}
+	return r
+}
+
+// Method returns the registered method named by n.  If no method with that
+// name has been registered, an error is returned.
+func (r *Router) Method(n string) (Method, error) {
+	m, ok := r.methods[strings.ToUpper(n)]
+	if !ok {
+		return Method{}, fmt.Errorf(errMethodNotDefined, n)
+	}
+	return m, nil
+}
+
+// UnregisterMethod removes a method from the router.  Routes that already
+// match the method are not changed.
+func (r *Router) UnregisterMethod(n string) {
+	delete(r.methods, strings.ToUpper(n))
+}
+
+// Method returns the properties of the request's method, as registered with
+// the router.  If the method has not been registered, only the name is set.
+func (r *Request) Method() Method {
+	name := strings.ToUpper(r.Request.Method)
+	if r.Route != nil {
+		if m, ok := r.Route.router.methods[name]; ok {
+			return m
+		}
+	}
+	return Method{Name: name}
+}
@@ -0,0 +1,128 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterRegisterMethod_invalid(t *testing.T) {
+	methods := []string{
+		// Empty names are not valid.
+		"",
+		// Separators are not allowed in tokens.
+		"GET POST",
+		"PROP(FIND)",
+		"QUERY/1",
+		// Neither are non-ASCII characters.
+		"GÉT",
+	}
+	router := NewRouter()
+
+	for _, m := range methods {
+		router.UnsetError()
+		router.RegisterMethod(m, true, true)
+		if router.Error() == nil {
+			t.Errorf("%v: Expected an error, received none.", m)
+		}
+		if _, err := router.Method(m); err == nil {
+			t.Errorf("%v: Expected method to not be registered.", m)
+		}
+	}
+}
+
+func TestRouterRegisterMethod(t *testing.T) {
+	router := NewRouter()
+	route := router.NewRoute()
+
+	// Unregistered methods can not be matched.
+	route.SetMethods("QUERY")
+	if route.Error() == nil {
+		t.Error("Expected an error, received none.")
+	}
+
+	// Registered methods can, and are not case sensitive.
+	router.RegisterMethod("query", true, true)
+	if router.Error() != nil {
+		t.Fatalf("Expected no error, received '%v'.", router.Error())
+	}
+	route.UnsetError()
+	route.SetMethods("Query")
+	if route.Error() != nil {
+		t.Errorf("Expected no error, received '%v'.", route.Error())
+	}
+	if !slicesAreSimilar(route.Methods(), []string{"QUERY"}) {
+		t.Errorf("Expected methods '[QUERY]', received '%v'.", route.Methods())
+	}
+	m, err := router.Method("query")
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	if m.Name != "QUERY" || !m.Safe || !m.Idempotent {
+		t.Errorf("Expected method '{QUERY true true}', received '%v'.", m)
+	}
+
+	// Registering an existing method replaces its properties.
+	router.RegisterMethod("QUERY", false, true)
+	if m, _ = router.Method("QUERY"); m.Safe {
+		t.Errorf("Expected method to not be safe, received '%v'.", m)
+	}
+
+	// Methods can be unregistered.
+	router.UnregisterMethod("QUERY")
+	if _, err = router.Method("QUERY"); err == nil {
+		t.Error("Expected an error, received none.")
+	}
+}
+
+func TestRouterMethod(t *testing.T) {
+	tests := []Method{
+		{"GET", true, true},
+		{"HEAD", true, true},
+		{"POST", false, false},
+		{"PUT", false, true},
+		{"DELETE", false, true},
+		{"PATCH", false, false},
+	}
+	router := NewRouter()
+
+	for _, v := range tests {
+		m, err := router.Method(v.Name)
+		if err != nil {
+			t.Errorf("%v: Expected no error, received '%v'.", v.Name, err)
+			continue
+		}
+		if m != v {
+			t.Errorf("Expected method '%v', received '%v'.", v, m)
+		}
+	}
+}
+
+func TestRouterRegisterWebDAVMethods(t *testing.T) {
+	var matched Method
+	router := NewRouter().RegisterWebDAVMethods()
+	for _, m := range []string{"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"} {
+		if _, err := router.Method(m); err != nil {
+			t.Errorf("%v: Expected no error, received '%v'.", m, err)
+		}
+	}
+	if m, _ := router.Method("PROPFIND"); !m.Safe {
+		t.Errorf("Expected PROPFIND to be safe, received '%v'.", m)
+	}
+	if m, _ := router.Method("LOCK"); m.Idempotent {
+		t.Errorf("Expected LOCK to not be idempotent, received '%v'.", m)
+	}
+
+	router.NewRoute().SetPath("/dav/").SetMethods("PROPFIND", "MKCOL").
+		SetHandler(func(w http.ResponseWriter, r *Request) {
+			matched = r.Method()
+		})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/dav/", nil))
+	if matched != (Method{"PROPFIND", true, true}) {
+		t.Errorf("Expected PROPFIND to match, received '%v'.", matched)
+	}
+}
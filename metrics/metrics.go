@@ -0,0 +1,283 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metrics records metrics for the requests handled by a router, and
+// serves them in the Prometheus text exposition format.
+//
+// A Collector is added to a router as an observer, and can be served from one
+// of the router's own routes:
+//
+//	collector := metrics.New()
+//	router := routing.NewRouter().SetObservers(collector)
+//	router.NewRoute().Mount("/metrics", collector)
+//
+// Requests are labelled by the name of the route that matched them, or the
+// route's path if it has no name, so that the number of series does not grow
+// with the number of distinct URLs.  Requests that match no route are counted
+// as not found, or as method not allowed if the router sends 405 responses.
+// See routing.Router.SetMethodNotAllowed.  Methods are labelled by name if
+// they are registered with the router, and as "OTHER" otherwise.  See
+// routing.Router.RegisterMethod.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	routing "github.com/timewasted/go-routing"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets used for
+// request latency histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// defaultRouter provides the default method registry, used to label requests
+// that match no route.
+var defaultRouter = routing.NewRouter()
+
+// A Collector records metrics for the requests handled by a router.  It
+// implements routing.Observer, and http.Handler to serve the metrics.
+type Collector struct {
+	mu               sync.Mutex
+	buckets          []float64
+	requests         map[requestKey]uint64
+	inFlight         map[routeKey]int64
+	durations        map[routeKey]*histogram
+	notFound         uint64
+	methodNotAllowed uint64
+}
+
+// routeKey identifies the series of a route and method.
+type routeKey struct {
+	route  string
+	method string
+}
+
+// requestKey identifies the series of a route, method, and status code.
+type requestKey struct {
+	routeKey
+	status int
+}
+
+// histogram holds the observations of a latency histogram.
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// New returns a new Collector that uses DefaultBuckets.
+func New() *Collector {
+	return &Collector{
+		buckets:   DefaultBuckets,
+		requests:  make(map[requestKey]uint64),
+		inFlight:  make(map[routeKey]int64),
+		durations: make(map[routeKey]*histogram),
+	}
+}
+
+// SetBuckets sets the upper bounds, in seconds, of the buckets used for
+// request latency histograms.  It must be called before any requests are
+// observed.
+func (c *Collector) SetBuckets(b ...float64) *Collector {
+	buckets := append([]float64(nil), b...)
+	sort.Float64s(buckets)
+	c.buckets = buckets
+	return c
+}
+
+// Buckets returns the upper bounds of the buckets used for request latency
+// histograms.
+func (c *Collector) Buckets() []float64 {
+	return c.buckets
+}
+
+// Observe records the request that the router has matched to route.
+// Requests that match no route are counted as not found or method not
+// allowed by the status sent to the client.  Any other response to such a
+// request, such as a server error, is recorded with an empty route label.
+func (c *Collector) Observe(w http.ResponseWriter, req *http.Request, route *routing.Route) (http.ResponseWriter, func()) {
+	sw := routing.NewStatusRecorder(w)
+	start := time.Now()
+	if route == nil {
+		return sw, func() {
+			switch sw.Status() {
+			case http.StatusNotFound:
+				c.mu.Lock()
+				c.notFound++
+				c.mu.Unlock()
+			case http.StatusMethodNotAllowed:
+				c.mu.Lock()
+				c.methodNotAllowed++
+				c.mu.Unlock()
+			default:
+				c.record(routeKey{method: methodLabel(defaultRouter, req.Method)}, sw.Status(), time.Since(start).Seconds())
+			}
+		}
+	}
+
+	key := routeKey{route: routeLabel(route), method: methodLabel(route.Router(), req.Method)}
+	c.mu.Lock()
+	c.inFlight[key]++
+	c.mu.Unlock()
+
+	return sw, func() {
+		c.mu.Lock()
+		c.inFlight[key]--
+		c.mu.Unlock()
+		c.record(key, sw.Status(), time.Since(start).Seconds())
+	}
+}
+
+// record records a completed request with the given status and duration in
+// seconds.
+func (c *Collector) record(key routeKey, status int, elapsed float64) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests[requestKey{key, status}]++
+	h, ok := c.durations[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(c.buckets))}
+		c.durations[key] = h
+	}
+	for i, le := range c.buckets {
+		if elapsed <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += elapsed
+	h.count++
+}
+
+// ServeHTTP serves the recorded metrics in the Prometheus text exposition
+// format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WriteTo(w)
+}
+
+// WriteTo writes the recorded metrics to w in the Prometheus text exposition
+// format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := new(strings.Builder)
+
+	b.WriteString("# HELP routing_requests_total Number of requests handled, by route, method, and status code.\n")
+	b.WriteString("# TYPE routing_requests_total counter\n")
+	requests := make([]requestKey, 0, len(c.requests))
+	for k := range c.requests {
+		requests = append(requests, k)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].routeKey != requests[j].routeKey {
+			return requests[i].routeKey.less(requests[j].routeKey)
+		}
+		return requests[i].status < requests[j].status
+	})
+	for _, k := range requests {
+		fmt.Fprintf(b, "routing_requests_total{route=%s,method=%s,status=\"%d\"} %d\n",
+			quote(k.route), quote(k.method), k.status, c.requests[k])
+	}
+
+	b.WriteString("# HELP routing_requests_in_flight Number of requests being handled, by route and method.\n")
+	b.WriteString("# TYPE routing_requests_in_flight gauge\n")
+	inFlight := make([]routeKey, 0, len(c.inFlight))
+	for k := range c.inFlight {
+		inFlight = append(inFlight, k)
+	}
+	sortRouteKeys(inFlight)
+	for _, k := range inFlight {
+		fmt.Fprintf(b, "routing_requests_in_flight{route=%s,method=%s} %d\n",
+			quote(k.route), quote(k.method), c.inFlight[k])
+	}
+
+	b.WriteString("# HELP routing_request_duration_seconds Time taken to handle requests, by route and method.\n")
+	b.WriteString("# TYPE routing_request_duration_seconds histogram\n")
+	durations := make([]routeKey, 0, len(c.durations))
+	for k := range c.durations {
+		durations = append(durations, k)
+	}
+	sortRouteKeys(durations)
+	for _, k := range durations {
+		h := c.durations[k]
+		labels := "route=" + quote(k.route) + ",method=" + quote(k.method)
+		var cumulative uint64
+		for i, le := range c.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "routing_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				labels, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(b, "routing_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(b, "routing_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(b, "routing_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	b.WriteString("# HELP routing_requests_not_found_total Number of requests that matched no route.\n")
+	b.WriteString("# TYPE routing_requests_not_found_total counter\n")
+	fmt.Fprintf(b, "routing_requests_not_found_total %d\n", c.notFound)
+
+	b.WriteString("# HELP routing_requests_method_not_allowed_total Number of requests that matched no route because of their method.\n")
+	b.WriteString("# TYPE routing_requests_method_not_allowed_total counter\n")
+	fmt.Fprintf(b, "routing_requests_method_not_allowed_total %d\n", c.methodNotAllowed)
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// less returns true if k sorts before o.
+func (k routeKey) less(o routeKey) bool {
+	if k.route != o.route {
+		return k.route < o.route
+	}
+	return k.method < o.method
+}
+
+// sortRouteKeys sorts keys by route, then method.
+func sortRouteKeys(keys []routeKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].less(keys[j])
+	})
+}
+
+// routeLabel returns the label used for route: its name, or its path if it
+// has no name.
+func routeLabel(route *routing.Route) string {
+	if name := route.Name(); name != "" {
+		return name
+	}
+	return route.Path()
+}
+
+// methodLabel returns the label used for the method m: its name if it is
+// registered with router, and otherwise "OTHER".
+func methodLabel(router *routing.Router, m string) string {
+	method, err := router.Method(m)
+	if err != nil {
+		return "OTHER"
+	}
+	return method.Name
+}
+
+// quote returns s as a quoted label value.
+func quote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
+
+// formatFloat formats f for use in the exposition format.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,149 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	routing "github.com/timewasted/go-routing"
+)
+
+func TestCollector(t *testing.T) {
+	collector := New().SetBuckets(10, 1)
+	if buckets := collector.Buckets(); len(buckets) != 2 || buckets[0] != 1 || buckets[1] != 10 {
+		t.Errorf("Expected buckets '[1 10]', received '%v'.", buckets)
+	}
+
+	router := routing.NewRouter().SetObservers(collector).SetMethodNotAllowed(nil)
+	router.NewRoute().SetPath("/users/{id:[0-9]+}").SetMethods("GET").
+		SetHandler(func(w http.ResponseWriter, req *routing.Request) {
+			if req.Params["id"] == "0" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		})
+	router.NewRoute().SetName("article").SetPath("/articles/{id:[0-9]+}").SetCORS(routing.NewCORSPolicy("*")).
+		SetHandler(func(w http.ResponseWriter, req *routing.Request) {
+			w.Write([]byte(`"quoted"`))
+		})
+	router.NewRoute().Mount("/metrics", collector)
+
+	requests := []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/users/1"},
+		{"GET", "/users/2"},
+		{"GET", "/users/0"},
+		{"POST", "/users/1"},
+		{"BREW", "/articles/1"},
+		{"GET", "/missing"},
+	}
+	for _, r := range requests {
+		req, _ := http.NewRequest(r.method, "http://example.com"+r.path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// Preflight requests are counted against the route they ask about, not
+	// as not found.
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/articles/1", nil)
+	req.Header.Set("Origin", "https://example.org")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	req, _ = http.NewRequest("GET", "http://example.com/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Expected the text exposition format, received '%v'.", w.Header().Get("Content-Type"))
+	}
+
+	expected := []string{
+		`# TYPE routing_requests_total counter`,
+		`routing_requests_total{route="/users/{id:[0-9]+}",method="GET",status="200"} 2`,
+		`routing_requests_total{route="/users/{id:[0-9]+}",method="GET",status="404"} 1`,
+		`routing_requests_total{route="article",method="OTHER",status="200"} 1`,
+		`routing_requests_total{route="article",method="OPTIONS",status="204"} 1`,
+		`# TYPE routing_requests_in_flight gauge`,
+		`routing_requests_in_flight{route="/users/{id:[0-9]+}",method="GET"} 0`,
+		`routing_requests_in_flight{route="/metrics",method="GET"} 1`,
+		`# TYPE routing_request_duration_seconds histogram`,
+		`routing_request_duration_seconds_bucket{route="/users/{id:[0-9]+}",method="GET",le="1"} 3`,
+		`routing_request_duration_seconds_bucket{route="/users/{id:[0-9]+}",method="GET",le="10"} 3`,
+		`routing_request_duration_seconds_bucket{route="/users/{id:[0-9]+}",method="GET",le="+Inf"} 3`,
+		`routing_request_duration_seconds_count{route="/users/{id:[0-9]+}",method="GET"} 3`,
+		`routing_requests_not_found_total 1`,
+		`routing_requests_method_not_allowed_total 1`,
+	}
+	body := w.Body.String()
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected the line '%v', received '%v'.", line, body)
+		}
+	}
+	if strings.Contains(body, "/users/1") || strings.Contains(body, "/missing") {
+		t.Errorf("Expected no raw URLs, received '%v'.", body)
+	}
+}
+
+func TestCollector_unmatched(t *testing.T) {
+	collector := New()
+
+	// Requests that match no route are classified by the status sent, such
+	// as when the router fails to read a route's parameters.
+	for _, status := range []int{http.StatusNotFound, http.StatusInternalServerError} {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		w, done := collector.Observe(httptest.NewRecorder(), req, nil)
+		w.WriteHeader(status)
+		done()
+	}
+
+	w := httptest.NewRecorder()
+	collector.WriteTo(w)
+	body := w.Body.String()
+	for _, line := range []string{
+		`routing_requests_total{route="",method="GET",status="500"} 1`,
+		`routing_requests_not_found_total 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected the line '%v', received '%v'.", line, body)
+		}
+	}
+}
+
+func TestCollector_methods(t *testing.T) {
+	collector := New()
+	router := routing.NewRouter().SetObservers(collector).
+		RegisterMethod("BREW", false, false).RegisterWebDAVMethods()
+	router.NewRoute().SetPath("/").SetHandler(func(w http.ResponseWriter, req *routing.Request) {})
+
+	// Methods registered with the router are labelled by name.
+	for _, method := range []string{"brew", "PROPFIND", "GET", "WHEN"} {
+		req, _ := http.NewRequest(method, "http://example.com/", nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	collector.WriteTo(w)
+	body := w.Body.String()
+	for _, line := range []string{
+		`routing_requests_total{route="/",method="BREW",status="200"} 1`,
+		`routing_requests_total{route="/",method="PROPFIND",status="200"} 1`,
+		`routing_requests_total{route="/",method="GET",status="200"} 1`,
+		`routing_requests_total{route="/",method="OTHER",status="200"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected the line '%v', received '%v'.", line, body)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if q := quote("a\\b\"c\nd"); q != `"a\\b\"c\nd"` {
+		t.Errorf("Expected '%v', received '%v'.", `"a\\b\"c\nd"`, q)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Error messages related to mounting.
+const (
+	errRouterMounted = "routing: Router is already mounted at '%s'."
+)
+
+// mountPrefixKey is the context key under which the path prefix stripped by
+// mount points is stored.
+type mountPrefixKey struct{}
+
+// Mount creates a new route that passes all requests under prefix on to the
+// router h.  See Route.Mount for details.
+func (r *Router) Mount(prefix string, h *Router) *Route {
+	return r.NewRoute().Mount(prefix, h)
+}
+
+// Mount sets the route to match all requests under prefix, and to pass them
+// on to h with the matched prefix removed from the request's URL.  The
+// original request is left untouched, so the full path is still visible once
+// h returns.  A trailing slash on prefix is ignored, and the prefix must match
+// whole path segments, so a prefix of "/api" matches "/api" and "/api/users",
+// but not "/apis".  Trailing slash handling is disabled for the route, since
+// that is left up to h.
+//
+// If h is a *Router, URLs built by its routes will include the mount point,
+// and will use the mount point's host and schemes unless they define their
+// own.  Redirects issued by h will also include the stripped prefix.  Since
+// a router can only have a single mount point, it can not be mounted more
+// than once.
+//
+// If parsing of the prefix fails, or h is a *Router that is already mounted
+// by another route, the route is not changed, and an error message is set on
+// the route.
+func (r *Route) Mount(prefix string, h http.Handler) *Route {
+	router, isRouter := h.(*Router)
+	if isRouter && router.mount != nil && router.mount != r {
+		r.err = fmt.Errorf(errRouterMounted, router.mount.Path())
+		return r
+	}
+	if prefix = strings.TrimSuffix(prefix, "/"); prefix == "" {
+		prefix = "/"
+	}
+	matchSlashes := r.matchSlashes
+	r.matchSlashes = false
+	parsedPath, err := r.parsePath(prefix, true)
+	if err != nil {
+		r.matchSlashes = matchSlashes
+		r.err = err
+		return r
+	}
+	if parsedPath.rawPath != "/" {
+		// Only match on whole path segments.
+		parsedPath.fwdPattern = regexp.MustCompile(parsedPath.fwdPattern.String() + "(?:/|$)")
+	}
+	r.path = parsedPath
+
+	if isRouter {
+		router.mount = r
+	}
+	r.handler = func(w http.ResponseWriter, req *Request) {
+		h.ServeHTTP(w, stripPrefix(req.Request, r.path))
+	}
+	return r
+}
+
+// stripPrefix returns a copy of req with the portion of the path matched by
+// p removed.
+func stripPrefix(req *http.Request, p *pathInfo) *http.Request {
+	loc := p.fwdPattern.FindStringIndex(req.URL.Path)
+	if loc == nil {
+		return req
+	}
+	prefix := strings.TrimSuffix(req.URL.Path[:loc[1]], "/")
+	rest := req.URL.Path[len(prefix):]
+	if rest == "" {
+		rest = "/"
+	}
+
+	ctx := context.WithValue(req.Context(), mountPrefixKey{}, mountPrefix(req)+prefix)
+	stripped := req.WithContext(ctx)
+	stripped.URL = new(url.URL)
+	*stripped.URL = *req.URL
+	stripped.URL.Path = rest
+	stripped.URL.RawPath = stripRawPrefix(req.URL.RawPath, prefix)
+	return stripped
+}
+
+// stripRawPrefix removes the escaped form of prefix from rawPath.  An empty
+// string is returned if rawPath is empty, or does not start with prefix.
+func stripRawPrefix(rawPath, prefix string) string {
+	if rawPath == "" {
+		return ""
+	}
+	for i := 0; i <= len(rawPath); i++ {
+		if i < len(rawPath) && rawPath[i] != '/' {
+			continue
+		}
+		if p, err := url.PathUnescape(rawPath[:i]); err == nil && p == prefix {
+			if i == len(rawPath) {
+				return "/"
+			}
+			return rawPath[i:]
+		}
+	}
+	return ""
+}
+
+// mountPrefix returns the path prefix that has been stripped from req by
+// any mount points that it has passed through.
+func mountPrefix(req *http.Request) string {
+	prefix, _ := req.Context().Value(mountPrefixKey{}).(string)
+	return prefix
+}
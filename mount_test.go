@@ -0,0 +1,204 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouteMount(t *testing.T) {
+	type mountTest struct {
+		path             string
+		code             int
+		innerPath        string
+		innerRawPath     string
+		innerMountPrefix string
+	}
+
+	var innerPath, innerRawPath, innerMountPrefix string
+	inner := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		innerPath = req.URL.Path
+		innerRawPath = req.URL.RawPath
+		innerMountPrefix = mountPrefix(req)
+	})
+	router := NewRouter()
+	router.NewRoute().Mount("/api/", inner)
+
+	requests := []mountTest{
+		{ // 0
+			path:             "/api",
+			code:             http.StatusOK,
+			innerPath:        "/",
+			innerMountPrefix: "/api",
+		},
+		{ // 1
+			path:             "/api/",
+			code:             http.StatusOK,
+			innerPath:        "/",
+			innerMountPrefix: "/api",
+		},
+		{ // 2
+			path:             "/api/users/1234",
+			code:             http.StatusOK,
+			innerPath:        "/users/1234",
+			innerMountPrefix: "/api",
+		},
+		{ // 3
+			path:             "/api/a/b%2Fc",
+			code:             http.StatusOK,
+			innerPath:        "/a/b/c",
+			innerRawPath:     "/a/b%2Fc",
+			innerMountPrefix: "/api",
+		},
+		{ // 4
+			// Prefixes only match whole path segments.
+			path: "/apis",
+			code: http.StatusNotFound,
+		},
+	}
+
+	for pos, r := range requests {
+		innerPath, innerRawPath, innerMountPrefix = "", "", ""
+		request, err := http.NewRequest("GET", r.path, nil)
+		if err != nil {
+			t.Fatalf("requests[%v]: Expected no error, received '%v'.", pos, err)
+		}
+		path := request.URL.Path
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != r.code {
+			t.Errorf("requests[%v]: Expected code %d, received %d.", pos, r.code, recorder.Code)
+		}
+		if innerPath != r.innerPath {
+			t.Errorf("requests[%v]: Expected inner path '%v', received '%v'.", pos, r.innerPath, innerPath)
+		}
+		if innerRawPath != r.innerRawPath {
+			t.Errorf("requests[%v]: Expected inner raw path '%v', received '%v'.", pos, r.innerRawPath, innerRawPath)
+		}
+		if innerMountPrefix != r.innerMountPrefix {
+			t.Errorf("requests[%v]: Expected mount prefix '%v', received '%v'.", pos, r.innerMountPrefix, innerMountPrefix)
+		}
+		// The original request is not modified.
+		if request.URL.Path != path {
+			t.Errorf("requests[%v]: Expected path '%v' to be unchanged, received '%v'.", pos, path, request.URL.Path)
+		}
+	}
+}
+
+func TestRouterMount(t *testing.T) {
+	var innerParams map[string]string
+	inner := NewRouter().SetMatchSlashes(true)
+	userRoute := inner.NewRoute().SetName("user").
+		Get("/users/{id:[0-9]+}/").
+		SetHandler(func(w http.ResponseWriter, req *Request) {
+			innerParams = req.Params
+		})
+
+	outer := NewRouter().SetHost("api.example.com").SetSchemes("https")
+	mount := outer.Mount("/teams/{team:[a-z]+}", inner)
+	if mount.Error() != nil {
+		t.Fatalf("Expected no error, received '%v'.", mount.Error())
+	}
+
+	// Requests are passed on to the inner router.
+	request, err := http.NewRequest("GET", "https://api.example.com/teams/go/users/42/", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	request.TLS = new(tls.ConnectionState)
+	recorder := httptest.NewRecorder()
+	outer.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected code %d, received %d.", http.StatusOK, recorder.Code)
+	}
+	if innerParams["id"] != "42" {
+		t.Errorf("Expected param 'id' to be '42', received '%v'.", innerParams)
+	}
+
+	// Redirects issued by the inner router include the mount point.
+	request, err = http.NewRequest("GET", "https://api.example.com/teams/go/users/42", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	request.TLS = new(tls.ConnectionState)
+	recorder = httptest.NewRecorder()
+	outer.ServeHTTP(recorder, request)
+	if location := recorder.Header().Get("Location"); location != "/teams/go/users/42/" {
+		t.Errorf("Expected location '/teams/go/users/42/', received '%v'.", location)
+	}
+
+	// URLs built by the inner router include the mount point, host and scheme.
+	u, err := userRoute.URL(map[string]string{"team": "go", "id": "42"})
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	if u.String() != "https://api.example.com/teams/go/users/42/" {
+		t.Errorf("Expected URL 'https://api.example.com/teams/go/users/42/', received '%v'.", u)
+	}
+
+	// Parameters of the mount point are required.
+	if _, err = userRoute.URL(map[string]string{"id": "42"}); err == nil {
+		t.Error("Expected an error, received none.")
+	}
+}
+
+func TestRouteMount_invalid(t *testing.T) {
+	router := NewRouter()
+	route := router.NewRoute().SetPath("/")
+	route.Mount("/{id:([a-z]+}", http.NotFoundHandler())
+	if route.Error() == nil {
+		t.Error("Expected an error, received none.")
+	}
+	if route.Path() != "/" {
+		t.Errorf("Expected path '/', received '%v'.", route.Path())
+	}
+}
+
+func TestRouteMount_twice(t *testing.T) {
+	router, api := NewRouter(), NewRouter()
+	first := router.Mount("/api", api)
+	if first.Error() != nil {
+		t.Fatalf("Expected no error, received '%v'.", first.Error())
+	}
+
+	// Mounting again at the same route is allowed.
+	first.Mount("/v1", api)
+	if first.Error() != nil {
+		t.Errorf("Expected no error, received '%v'.", first.Error())
+	}
+
+	// Mounting at a different route is not.
+	second := router.NewRoute().SetPath("/")
+	second.Mount("/other", api)
+	if second.Error() == nil {
+		t.Error("Expected an error, received none.")
+	}
+	if second.Path() != "/" {
+		t.Errorf("Expected path '/', received '%v'.", second.Path())
+	}
+	if api.mount != first {
+		t.Error("Expected the router to remain mounted at the first route.")
+	}
+}
+
+func TestHelper_stripRawPrefix(t *testing.T) {
+	tests := [][]string{
+		// rawPath, prefix, expected
+		{"", "/api", ""},
+		{"/api/a%2Fb", "/api", "/a%2Fb"},
+		{"/api", "/api", "/"},
+		{"/a%2Fb/c", "/a/b", "/c"},
+		{"/other/a%2Fb", "/api", ""},
+	}
+
+	for _, v := range tests {
+		if stripped := stripRawPrefix(v[0], v[1]); stripped != v[2] {
+			t.Errorf("Expected '%v' stripped of '%v' to be '%v', received '%v'.", v[0], v[1], v[2], stripped)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"net/http"
+)
+
+// An Observer is notified of each request that a router handles, such as to
+// record metrics or traces.
+type Observer interface {
+	// Observe is called once the router has matched req against its routes,
+	// before any handler is called.  route is the last matched route, or nil
+	// if no route matched.  Observe returns the writer that the response
+	// should be written to, which is usually w or a wrapper around it, and a
+	// function that is called once the response is complete.
+	Observe(w http.ResponseWriter, req *http.Request, route *Route) (http.ResponseWriter, func())
+}
+
+// SetObservers sets the list of observers that are notified of each request.
+// Observers are nested in order, so each observer is given the writer returned
+// by the observer before it.
+func (r *Router) SetObservers(o ...Observer) *Router {
+	r.observers = o
+	return r
+}
+
+// Observers returns the list of observers that are notified of each request.
+func (r *Router) Observers() []Observer {
+	return r.observers
+}
+
+// UnsetObservers clears the list of observers that are notified of each
+// request.
+func (r *Router) UnsetObservers() {
+	r.observers = nil
+}
+
+// A StatusRecorder is a http.ResponseWriter that records the status code and
+// size of the response written through it, such as for an observer to report.
+// Flushing, hijacking, and http.ResponseController work as they do with the
+// underlying writer.
+type StatusRecorder struct {
+	statusWriter
+}
+
+// NewStatusRecorder returns a new StatusRecorder that writes to w.
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{statusWriter{ResponseWriter: w}}
+}
+
+// Status returns the status code of the response, or 0 if nothing has been
+// written yet.
+func (sr *StatusRecorder) Status() int {
+	return sr.status
+}
+
+// BytesWritten returns the number of bytes of the response body written so
+// far.
+func (sr *StatusRecorder) BytesWritten() int64 {
+	return sr.bytes
+}
@@ -0,0 +1,159 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// recordingObserver records the routes it observes, and the order in which
+// requests finish.
+type recordingObserver struct {
+	name  string
+	calls *[]string
+}
+
+func (o recordingObserver) Observe(w http.ResponseWriter, req *http.Request, route *Route) (http.ResponseWriter, func()) {
+	path := "<nil>"
+	if route != nil {
+		path = route.Path()
+	}
+	*o.calls = append(*o.calls, o.name+" start "+path)
+	return w, func() {
+		*o.calls = append(*o.calls, o.name+" done")
+	}
+}
+
+func TestRouterObservers(t *testing.T) {
+	var calls []string
+	router := NewRouter().SetObservers(recordingObserver{"a", &calls}, recordingObserver{"b", &calls})
+	if len(router.Observers()) != 2 {
+		t.Errorf("Expected 2 observers, received '%v'.", len(router.Observers()))
+	}
+	router.NewRoute().SetPath("/").SetHandler(func(w http.ResponseWriter, req *Request) {
+		calls = append(calls, "handler")
+	})
+
+	for _, path := range []string{"/", "/missing"} {
+		req, _ := http.NewRequest("GET", "http://example.com"+path, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	expected := []string{
+		"a start /", "b start /", "handler", "b done", "a done",
+		"a start <nil>", "b start <nil>", "b done", "a done",
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls '%v', received '%v'.", expected, calls)
+	}
+
+	// Preflight requests answered by the router are observed as requests to
+	// the route they ask about.
+	calls = nil
+	router.NewRoute().SetPath("/items").SetMethods("PUT").SetCORS(NewCORSPolicy("*"))
+	req, _ := http.NewRequest("OPTIONS", "http://example.com/items", nil)
+	req.Header.Set("Origin", "https://example.org")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	expected = []string{"a start /items", "b start /items", "b done", "a done"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls '%v', received '%v'.", expected, calls)
+	}
+
+	router.UnsetObservers()
+	if len(router.Observers()) != 0 {
+		t.Errorf("Expected no observers, received '%v'.", len(router.Observers()))
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	router := NewRouter()
+	handler := func(w http.ResponseWriter, req *Request) {}
+	router.NewRoute().SetPath("/users").SetMethods("GET", "HEAD").SetHandler(handler)
+	router.NewRoute().SetPath("/users").SetMethods("POST").SetHandler(handler)
+	api := router.NewRoute().SetPrefix("/api/")
+	api.Subroute().SetPath("/items").SetMethods("PUT").SetHandler(handler)
+
+	type notAllowedTest struct {
+		method string
+		path   string
+		code   int
+		allow  string
+	}
+
+	// By default, requests with the wrong method are not found.
+	req, _ := http.NewRequest("DELETE", "http://example.com/users", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound || w.Header().Get("Allow") != "" {
+		t.Errorf("Expected code '404' and no allow, received '%v' and '%v'.", w.Code, w.Header().Get("Allow"))
+	}
+
+	router.SetMethodNotAllowed(nil)
+	tests := []notAllowedTest{
+		{"GET", "/users", http.StatusOK, ""},                                 // 0
+		{"DELETE", "/users", http.StatusMethodNotAllowed, "GET, HEAD, POST"}, // 1
+		{"DELETE", "/groups", http.StatusNotFound, ""},                       // 2
+		{"GET", "/api/items", http.StatusMethodNotAllowed, "PUT"},            // 3
+		{"GET", "/api/other", http.StatusNotFound, ""},                       // 4
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest(test.method, "http://example.com"+test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("tests[%v]: Expected code '%v', received '%v'.", i, test.code, w.Code)
+		}
+		if w.Header().Get("Allow") != test.allow {
+			t.Errorf("tests[%v]: Expected allow '%v', received '%v'.", i, test.allow, w.Header().Get("Allow"))
+		}
+	}
+
+	// A custom handler can be used instead.
+	router.SetMethodNotAllowed(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	if router.MethodNotAllowed() == nil {
+		t.Error("Expected a handler, received none.")
+	}
+	req, _ = http.NewRequest("DELETE", "http://example.com/users", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusTeapot {
+		t.Errorf("Expected code '418', received '%v'.", w.Code)
+	}
+	if w.Header().Get("Allow") != "GET, HEAD, POST" {
+		t.Errorf("Expected allow 'GET, HEAD, POST', received '%v'.", w.Header().Get("Allow"))
+	}
+
+	// Unsetting the handler restores the default.
+	router.UnsetMethodNotAllowed()
+	req, _ = http.NewRequest("GET", "http://example.com/api/items", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected code '404', received '%v'.", w.Code)
+	}
+}
+
+func TestStatusRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	sr := NewStatusRecorder(w)
+	if sr.Status() != 0 {
+		t.Errorf("Expected status '0', received '%v'.", sr.Status())
+	}
+	sr.WriteHeader(http.StatusCreated)
+	sr.WriteHeader(http.StatusOK)
+	sr.Write([]byte("hello"))
+	if sr.Status() != http.StatusCreated || sr.BytesWritten() != 5 {
+		t.Errorf("Expected '201' and '5' bytes, received '%v' and '%v'.", sr.Status(), sr.BytesWritten())
+	}
+	if sr.Unwrap() != w {
+		t.Error("Expected the underlying writer.")
+	}
+}
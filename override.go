@@ -0,0 +1,118 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// originalMethodKey is the context key under which the method of a request
+// is stored before being overridden.
+type originalMethodKey struct{}
+
+// The headers that can be used to override the method of a request, in order
+// of precedence.
+var overrideHeaders = []string{
+	"X-HTTP-Method-Override",
+	"X-Method-Override",
+}
+
+// SetMethodOverride enables method overriding, allowing POST requests to be
+// treated as one of the provided methods.  The method to use is taken from
+// the X-HTTP-Method-Override or X-Method-Override header, or from the form
+// field set by SetMethodOverrideField when the request contains a form.  The
+// override is applied before routes are matched, and is ignored if the method
+// is not in the list.  If a method that has not been registered with the
+// router is provided, method overriding is not changed, and an error message
+// is set on the router.
+//
+// The original method of the request is available from
+// Request.OriginalMethod.
+func (r *Router) SetMethodOverride(m ...string) *Router {
+	methods, err := validateMethods(r.methods, m...)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.overrideMethods = methods
+	return r
+}
+
+// MethodOverride returns the list of methods that a request can be
+// overridden to.
+func (r *Router) MethodOverride() []string {
+	m := make([]string, 0, len(r.overrideMethods))
+	for k := range r.overrideMethods {
+		m = append(m, k)
+	}
+	return m
+}
+
+// UnsetMethodOverride disables method overriding.
+func (r *Router) UnsetMethodOverride() {
+	r.overrideMethods = nil
+}
+
+// SetMethodOverrideField sets the name of the form field used to override
+// the method of a request.  The default is "_method".  An empty name prevents
+// forms from overriding the method.
+func (r *Router) SetMethodOverrideField(f string) *Router {
+	r.overrideField = f
+	return r
+}
+
+// MethodOverrideField returns the name of the form field used to override
+// the method of a request.
+func (r *Router) MethodOverrideField() string {
+	return r.overrideField
+}
+
+// OriginalMethod returns the method that the client sent, before any method
+// override was applied.
+func (r *Request) OriginalMethod() string {
+	if m, ok := r.Request.Context().Value(originalMethodKey{}).(string); ok {
+		return m
+	}
+	return r.Request.Method
+}
+
+// overrideMethod returns req with its method overridden, if method
+// overriding is enabled and the request asks for an allowed method.
+// Otherwise, req is returned unchanged.  The override field of a form is only
+// read if form is true, since reading it consumes the request body.
+func (r *Router) overrideMethod(req *http.Request, form bool) *http.Request {
+	if len(r.overrideMethods) == 0 || req.Method != "POST" {
+		return req
+	}
+
+	var method string
+	for _, h := range overrideHeaders {
+		if method = req.Header.Get(h); method != "" {
+			break
+		}
+	}
+	if method == "" && form && r.overrideField != "" && isForm(req) {
+		method = req.PostFormValue(r.overrideField)
+	}
+	if method = strings.ToUpper(strings.TrimSpace(method)); !r.overrideMethods[method] {
+		return req
+	}
+
+	overridden := req.WithContext(context.WithValue(req.Context(), originalMethodKey{}, req.Method))
+	overridden.Method = method
+	return overridden
+}
+
+// isForm returns true if the body of req contains a HTML form.
+func isForm(req *http.Request) bool {
+	contentType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return contentType == "application/x-www-form-urlencoded" || contentType == "multipart/form-data"
+}
@@ -0,0 +1,139 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouterMethodOverride(t *testing.T) {
+	router := NewRouter()
+
+	// The default state is empty.
+	if len(router.MethodOverride()) != 0 {
+		t.Errorf("Expected no methods, received '%v'.", router.MethodOverride())
+	}
+	if router.MethodOverrideField() != "_method" {
+		t.Errorf("Expected field '_method', received '%v'.", router.MethodOverrideField())
+	}
+
+	// Methods must be registered.
+	router.SetMethodOverride("PUT", "BOGUS")
+	if router.Error() == nil {
+		t.Error("Expected an error, received none.")
+	}
+	if len(router.MethodOverride()) != 0 {
+		t.Errorf("Expected no methods, received '%v'.", router.MethodOverride())
+	}
+
+	router.UnsetError()
+	router.SetMethodOverride("put", "DELETE")
+	if router.Error() != nil {
+		t.Errorf("Expected no error, received '%v'.", router.Error())
+	}
+	if !slicesAreSimilar(router.MethodOverride(), []string{"PUT", "DELETE"}) {
+		t.Errorf("Expected methods '[PUT DELETE]', received '%v'.", router.MethodOverride())
+	}
+
+	// Method overriding can be disabled.
+	router.UnsetMethodOverride()
+	if len(router.MethodOverride()) != 0 {
+		t.Errorf("Expected no methods, received '%v'.", router.MethodOverride())
+	}
+}
+
+func TestRouterMethodOverride_request(t *testing.T) {
+	type overrideTest struct {
+		method      string
+		headers     http.Header
+		body        string
+		method2     string
+		original    string
+		formEnabled bool
+	}
+
+	tests := []overrideTest{
+		{ // 0
+			method:  "POST",
+			headers: http.Header{"X-Http-Method-Override": {"DELETE"}},
+			method2: "DELETE",
+		},
+		{ // 1
+			method:  "POST",
+			headers: http.Header{"X-Method-Override": {"put"}},
+			method2: "PUT",
+		},
+		{ // 2
+			// Only allowed methods can be used.
+			method:  "POST",
+			headers: http.Header{"X-Http-Method-Override": {"PATCH"}},
+			method2: "POST",
+		},
+		{ // 3
+			// Only POST requests can be overridden.
+			method:  "GET",
+			headers: http.Header{"X-Http-Method-Override": {"DELETE"}},
+			method2: "GET",
+		},
+		{ // 4
+			method:      "POST",
+			headers:     http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+			body:        "_method=DELETE&name=value",
+			method2:     "DELETE",
+			formEnabled: true,
+		},
+		{ // 5
+			// The form field can be disabled.
+			method:  "POST",
+			headers: http.Header{"Content-Type": {"application/x-www-form-urlencoded"}},
+			body:    "_method=DELETE",
+			method2: "POST",
+		},
+		{ // 6
+			// The body is only used if it contains a form.
+			method:      "POST",
+			headers:     http.Header{"Content-Type": {"text/plain"}},
+			body:        "_method=DELETE",
+			method2:     "POST",
+			formEnabled: true,
+		},
+	}
+
+	var method, original, formValue string
+	router := NewRouter().SetMethodOverride("PUT", "DELETE")
+	router.NewRoute().SetPath("/").SetHandler(func(w http.ResponseWriter, r *Request) {
+		method, original, formValue = r.Request.Method, r.OriginalMethod(), r.Request.PostFormValue("name")
+	})
+
+	for pos, v := range tests {
+		if v.formEnabled {
+			router.SetMethodOverrideField("_method")
+		} else {
+			router.SetMethodOverrideField("")
+		}
+		request := httptest.NewRequest(v.method, "/", strings.NewReader(v.body))
+		for k, values := range v.headers {
+			request.Header[k] = values
+		}
+		method, original, formValue = "", "", ""
+		router.ServeHTTP(httptest.NewRecorder(), request)
+		if method != v.method2 {
+			t.Errorf("tests[%v]: Expected method '%v', received '%v'.", pos, v.method2, method)
+		}
+		if original != v.method {
+			t.Errorf("tests[%v]: Expected original method '%v', received '%v'.", pos, v.method, original)
+		}
+		if strings.Contains(v.body, "name=value") && formValue != "value" {
+			t.Errorf("tests[%v]: Expected form value 'value', received '%v'.", pos, formValue)
+		}
+		// The original request is not modified.
+		if request.Method != v.method {
+			t.Errorf("tests[%v]: Expected request method '%v', received '%v'.", pos, v.method, request.Method)
+		}
+	}
+}
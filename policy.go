@@ -0,0 +1,229 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// A Policy decides whether the client making a request may access a route.
+type Policy struct {
+	desc  string
+	allow func(p *Principal, req *Request) bool
+}
+
+// NewPolicy returns a new Policy that allows a request if allow returns true.
+// The principal passed to allow is nil if the client was not authenticated.
+// desc describes the policy in reports.
+func NewPolicy(desc string, allow func(p *Principal, req *Request) bool) *Policy {
+	return &Policy{desc: desc, allow: allow}
+}
+
+// String returns the description of the policy.
+func (p *Policy) String() string {
+	return p.desc
+}
+
+// Allows returns true if the policy allows the request.
+func (p *Policy) Allows(principal *Principal, req *Request) bool {
+	return p.allow(principal, req)
+}
+
+// HasRole returns a Policy that allows clients that have any of roles.
+func HasRole(roles ...string) *Policy {
+	return NewPolicy("role("+strings.Join(roles, ", ")+")", func(p *Principal, req *Request) bool {
+		return p != nil && sliceContainsAny(p.Roles, roles)
+	})
+}
+
+// HasPermission returns a Policy that allows clients that have any of perms.
+func HasPermission(perms ...string) *Policy {
+	return NewPolicy("permission("+strings.Join(perms, ", ")+")", func(p *Principal, req *Request) bool {
+		return p != nil && sliceContainsAny(p.Permissions, perms)
+	})
+}
+
+// ParamIsPrincipal returns a Policy that allows clients whose ID is the value
+// of the route parameter named param, such as a user who may only change
+// "/users/{id}" for their own id.
+func ParamIsPrincipal(param string) *Policy {
+	return NewPolicy("param("+param+") == principal", func(p *Principal, req *Request) bool {
+		return p != nil && p.ID != "" && req.Params[param] == p.ID
+	})
+}
+
+// AllOf returns a Policy that allows a request if all of policies do.
+func AllOf(policies ...*Policy) *Policy {
+	return NewPolicy(groupPolicies(policies, " and "), func(p *Principal, req *Request) bool {
+		for _, policy := range policies {
+			if !policy.allow(p, req) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// AnyOf returns a Policy that allows a request if any of policies do.
+func AnyOf(policies ...*Policy) *Policy {
+	return NewPolicy(groupPolicies(policies, " or "), func(p *Principal, req *Request) bool {
+		return anyPolicyAllows(policies, p, req)
+	})
+}
+
+// Allow sets the policies that decide who may access the route.  A request
+// is allowed if any of policies allows it, and otherwise receives a 403
+// Forbidden response, without any handlers being called.  Policies are
+// checked after authentication, so the client is available to them.  See
+// Route.Require.
+//
+// A route is restricted if it, or any route above it in a Subroute tree, is.
+// The policies of the last matched route that has any are used.
+func (r *Route) Allow(policies ...*Policy) *Route {
+	r.policies = policies
+	return r
+}
+
+// Allowed returns the policies that decide who may access the route.
+func (r *Route) Allowed() []*Policy {
+	return r.policies
+}
+
+// UnsetAllowed clears the policies that decide who may access the route.
+func (r *Route) UnsetAllowed() {
+	r.policies = nil
+}
+
+// A PolicyEntry describes the access control of a route, as listed by
+// Router.PolicyReport.
+type PolicyEntry struct {
+	Route         *Route
+	Name          string
+	Methods       []string
+	Host          string
+	Path          string
+	Authenticated bool   // Whether the route requires authentication
+	Policy        string // Description of the route's policies, if any
+}
+
+// PolicyReport returns the access control of every route of the router,
+// including those in Subroute trees, in the order that they are matched.
+// Routes inherit the authenticators and policies of the routes above them,
+// as they do when handling requests.
+func (r *Router) PolicyReport() []PolicyEntry {
+	var entries []PolicyEntry
+	var walk func(routes []*Route, chain []*Route)
+	walk = func(routes []*Route, chain []*Route) {
+		for _, route := range routes {
+			c := append(chain[:len(chain):len(chain)], route)
+			methods := route.Methods()
+			sort.Strings(methods)
+			entries = append(entries, PolicyEntry{
+				Route:         route,
+				Name:          route.Name(),
+				Methods:       methods,
+				Host:          route.Host(),
+				Path:          route.Path(),
+				Authenticated: len(requiredAuth(c)) > 0,
+				Policy:        joinPolicies(requiredPolicies(c), " or "),
+			})
+			walk(route.children, c)
+		}
+	}
+	walk(r.routes, nil)
+	return entries
+}
+
+// WritePolicyReport writes the router's PolicyReport to w as a table, for
+// review.
+func (r *Router) WritePolicyReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tMETHODS\tHOST\tPATH\tAUTHENTICATED\tPOLICY")
+	for _, e := range r.PolicyReport() {
+		methods, policy := strings.Join(e.Methods, ","), e.Policy
+		if methods == "" {
+			methods = "*"
+		}
+		if policy == "" {
+			policy = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%s\n", orDash(e.Name), methods, orDash(e.Host), orDash(e.Path), e.Authenticated, policy)
+	}
+	return tw.Flush()
+}
+
+// orDash returns s, or "-" if s is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// joinPolicies returns the descriptions of policies joined by sep.
+func joinPolicies(policies []*Policy, sep string) string {
+	desc := make([]string, len(policies))
+	for i, p := range policies {
+		desc[i] = p.desc
+	}
+	return strings.Join(desc, sep)
+}
+
+// groupPolicies returns the descriptions of policies joined by sep, in
+// parentheses if there is more than one.
+func groupPolicies(policies []*Policy, sep string) string {
+	if len(policies) == 1 {
+		return policies[0].desc
+	}
+	return "(" + joinPolicies(policies, sep) + ")"
+}
+
+// sliceContainsAny returns true if s contains any of values.
+func sliceContainsAny(s []string, values []string) bool {
+	for _, v := range values {
+		if sliceContainsString(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyPolicyAllows returns true if any of policies allows the request.
+func anyPolicyAllows(policies []*Policy, p *Principal, req *Request) bool {
+	for _, policy := range policies {
+		if policy.allow(p, req) {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredPolicies returns the policies of the last route in chain that has
+// any.
+func requiredPolicies(chain []*Route) []*Policy {
+	for i := len(chain) - 1; i >= 0; i-- {
+		if len(chain[i].policies) > 0 {
+			return chain[i].policies
+		}
+	}
+	return nil
+}
+
+// withPolicies returns h wrapped so that only requests allowed by one of
+// policies can access it.
+func (r *Router) withPolicies(policies []*Policy, h HandlerFunc) HandlerFunc {
+	return func(w http.ResponseWriter, req *Request) {
+		if !anyPolicyAllows(policies, req.Principal(), req) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h(w, req)
+	}
+}
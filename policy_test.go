@@ -0,0 +1,107 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRouteAllow(t *testing.T) {
+	handler := func(w http.ResponseWriter, req *Request) {}
+	auth := BearerAuth("api", func(token string) *Principal {
+		switch token {
+		case "admin":
+			return &Principal{ID: "1", Roles: []string{"admin"}}
+		case "user":
+			return &Principal{ID: "2", Roles: []string{"user"}, Permissions: []string{"reports:read"}}
+		}
+		return nil
+	})
+	router := NewRouter()
+	users := router.NewRoute().SetPrefix("/users/").Require(auth).Allow(HasRole("admin"))
+	users.Subroute().SetPath("/{id}").SetMethods("PUT").Allow(HasRole("admin"), ParamIsPrincipal("id")).SetHandler(handler)
+	users.Subroute().SetPath("/{id}").SetMethods("DELETE").SetHandler(handler)
+	router.NewRoute().SetPath("/reports").Require(auth).
+		Allow(AllOf(HasRole("user"), HasPermission("reports:read"))).SetHandler(handler)
+	// Policies without authentication see no principal.
+	router.NewRoute().SetPath("/open").Allow(HasRole("admin")).SetHandler(handler)
+
+	type allowTest struct {
+		method string
+		path   string
+		token  string
+		code   int
+	}
+
+	tests := []allowTest{
+		{"PUT", "/users/2", "user", http.StatusOK},           // 0
+		{"PUT", "/users/1", "user", http.StatusForbidden},    // 1
+		{"PUT", "/users/2", "admin", http.StatusOK},          // 2
+		{"DELETE", "/users/2", "user", http.StatusForbidden}, // 3
+		{"DELETE", "/users/2", "admin", http.StatusOK},       // 4
+		{"PUT", "/users/2", "", http.StatusUnauthorized},     // 5
+		{"GET", "/reports", "user", http.StatusOK},           // 6
+		{"GET", "/reports", "admin", http.StatusForbidden},   // 7
+		{"GET", "/open", "admin", http.StatusForbidden},      // 8
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest(test.method, "http://example.com"+test.path, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("tests[%v]: Expected status '%v', received '%v'.", i, test.code, w.Code)
+		}
+	}
+}
+
+func TestRouterPolicyReport(t *testing.T) {
+	auth := BearerAuth("api", func(token string) *Principal { return nil })
+	router := NewRouter()
+	router.NewRoute().SetName("home").SetPath("/")
+	users := router.NewRoute().SetPrefix("/users/").Require(auth)
+	users.Subroute().SetName("user.edit").SetPath("/{id}").SetMethods("PUT", "PATCH").
+		Allow(HasRole("admin"), ParamIsPrincipal("id"))
+	users.Subroute().SetPath("/{id}").SetMethods("DELETE").Allow(AllOf(HasRole("admin"), HasPermission("users:delete")))
+
+	type reportEntry struct {
+		Name          string
+		Methods       []string
+		Path          string
+		Authenticated bool
+		Policy        string
+	}
+
+	expected := []reportEntry{
+		{"home", []string{}, "/", false, ""},  // 0
+		{"", []string{}, "/users/", true, ""}, // 1
+		{"user.edit", []string{"PATCH", "PUT"}, "/users/{id}", true, "role(admin) or param(id) == principal"}, // 2
+		{"", []string{"DELETE"}, "/users/{id}", true, "(role(admin) and permission(users:delete))"},           // 3
+	}
+	var received []reportEntry
+	for _, e := range router.PolicyReport() {
+		received = append(received, reportEntry{e.Name, e.Methods, e.Path, e.Authenticated, e.Policy})
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Errorf("Expected report '%v', received '%v'.", expected, received)
+	}
+
+	var buf bytes.Buffer
+	if err := router.WritePolicyReport(&buf); err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "NAME") || strings.Join(strings.Fields(lines[1]), " ") != "home * - / false -" {
+		t.Errorf("Expected a table with a header and 4 routes, received '%v'.", buf.String())
+	}
+}
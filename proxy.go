@@ -0,0 +1,268 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// forwardedKey is the context key under which forwardedInfo is stored.
+type forwardedKey struct{}
+
+// forwardedInfo holds the details of a request as seen by the client, as
+// reported by trusted proxies.
+type forwardedInfo struct {
+	scheme   string
+	host     string
+	clientIP string
+}
+
+// SetTrustedProxies sets the list of proxies that the router trusts to report
+// the original scheme, host, and client address of a request.  Each proxy is
+// either an IP address, or a CIDR such as "10.0.0.0/8".  If an invalid proxy
+// is provided, no proxies are set, and an error message is set on the router.
+//
+// When a request comes directly from a trusted proxy, the Forwarded header
+// (RFC 7239) is used if present, and the X-Forwarded-For, X-Forwarded-Proto,
+// and X-Forwarded-Host headers are used otherwise.  The Forwarded header is
+// followed back through each trusted proxy, and the element added by the
+// last trusted proxy is used.  For the X-Forwarded-* headers, the value added
+// by the proxy the request came from is used.
+//
+// The reported scheme and host are used when matching routes, and are
+// available from Request.Scheme, Request.Host, and Request.AbsoluteURL.
+func (r *Router) SetTrustedProxies(p ...string) *Router {
+	proxies := make([]*net.IPNet, 0, len(p))
+	for _, v := range p {
+		cidr := v
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			r.err = fmt.Errorf(errInvalidProxy, v)
+			return r
+		}
+		proxies = append(proxies, ipNet)
+	}
+	r.trustedProxies = proxies
+	return r
+}
+
+// TrustedProxies returns the list of proxies that the router trusts.
+func (r *Router) TrustedProxies() []string {
+	p := make([]string, 0, len(r.trustedProxies))
+	for _, v := range r.trustedProxies {
+		p = append(p, v.String())
+	}
+	return p
+}
+
+// UnsetTrustedProxies clears the list of proxies that the router trusts.
+func (r *Router) UnsetTrustedProxies() {
+	r.trustedProxies = nil
+}
+
+// Scheme returns the scheme that the client used to make the request, either
+// "http" or "https".
+func (r *Request) Scheme() string {
+	return requestScheme(r.Request)
+}
+
+// Host returns the host that the client made the request to.
+func (r *Request) Host() string {
+	return requestHost(r.Request)
+}
+
+// ClientIP returns the IP address of the client that made the request.
+func (r *Request) ClientIP() string {
+	if info, ok := r.Request.Context().Value(forwardedKey{}).(*forwardedInfo); ok && info.clientIP != "" {
+		return info.clientIP
+	}
+	return stripPort(r.Request.RemoteAddr)
+}
+
+// AbsoluteURL returns a copy of u, with the scheme and host that the client
+// used to make the request filled in if u does not have them.
+func (r *Request) AbsoluteURL(u *url.URL) *url.URL {
+	abs := new(url.URL)
+	*abs = *u
+	if abs.Host == "" {
+		abs.Host = r.Host()
+	}
+	if abs.Scheme == "" {
+		abs.Scheme = r.Scheme()
+	}
+	return abs
+}
+
+// forwarded returns req with the details reported by trusted proxies added
+// to its context.  If req did not come from a trusted proxy, it is returned
+// unchanged.
+func (r *Router) forwarded(req *http.Request) *http.Request {
+	if len(r.trustedProxies) == 0 || !r.isTrustedProxy(stripPort(req.RemoteAddr)) {
+		return req
+	}
+
+	info := new(forwardedInfo)
+	if values := req.Header["Forwarded"]; len(values) > 0 {
+		elements := parseForwarded(values)
+		for i := len(elements) - 1; i >= 0; i-- {
+			// Keep going while the element was added on behalf of another
+			// trusted proxy.
+			clientIP := stripPort(elements[i]["for"])
+			if i > 0 && r.isTrustedProxy(clientIP) {
+				continue
+			}
+			info.scheme = elements[i]["proto"]
+			info.host = elements[i]["host"]
+			info.clientIP = clientIP
+			break
+		}
+	} else {
+		addrs := splitHeader(req.Header["X-Forwarded-For"])
+		for i := len(addrs) - 1; i >= 0; i-- {
+			clientIP := stripPort(addrs[i])
+			if i > 0 && r.isTrustedProxy(clientIP) {
+				continue
+			}
+			info.clientIP = clientIP
+			break
+		}
+		if v := splitHeader(req.Header["X-Forwarded-Proto"]); len(v) > 0 {
+			info.scheme = v[len(v)-1]
+		}
+		if v := splitHeader(req.Header["X-Forwarded-Host"]); len(v) > 0 {
+			info.host = v[len(v)-1]
+		}
+	}
+
+	if info.scheme = strings.ToLower(info.scheme); info.scheme != "http" && info.scheme != "https" {
+		info.scheme = ""
+	}
+	if strings.ContainsAny(info.host, " /\\?#@") {
+		info.host = ""
+	}
+	if net.ParseIP(info.clientIP) == nil {
+		info.clientIP = ""
+	}
+	return req.WithContext(context.WithValue(req.Context(), forwardedKey{}, info))
+}
+
+// isTrustedProxy returns true if addr is the address of a trusted proxy.
+func (r *Router) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, p := range r.trustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestScheme returns the scheme that the client used to make req.
+func requestScheme(req *http.Request) string {
+	if info, ok := req.Context().Value(forwardedKey{}).(*forwardedInfo); ok && info.scheme != "" {
+		return info.scheme
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestHost returns the host that the client made req to.
+func requestHost(req *http.Request) string {
+	if info, ok := req.Context().Value(forwardedKey{}).(*forwardedInfo); ok && info.host != "" {
+		return info.host
+	}
+	return req.Host
+}
+
+// parseForwarded parses the values of a Forwarded header into a list of
+// elements, each of which maps lower cased parameter names to their values.
+func parseForwarded(values []string) []map[string]string {
+	var elements []map[string]string
+	for _, v := range values {
+		element := make(map[string]string)
+		for len(v) > 0 {
+			var pair string
+			pair, v = nextForwardedPair(v)
+			if eq := strings.IndexByte(pair, '='); eq > 0 {
+				name := strings.ToLower(strings.TrimSpace(pair[:eq]))
+				value := strings.TrimSpace(pair[eq+1:])
+				if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+					value = strings.Replace(value[1:len(value)-1], "\\", "", -1)
+				}
+				element[name] = value
+			}
+			if len(v) > 0 && v[0] == ',' {
+				elements = append(elements, element)
+				element = make(map[string]string)
+			}
+			if len(v) > 0 {
+				v = v[1:]
+			}
+		}
+		elements = append(elements, element)
+	}
+	return elements
+}
+
+// nextForwardedPair returns the next name=value pair in s, and the remainder
+// of s, starting with the ';' or ',' that ended the pair.
+func nextForwardedPair(s string) (string, string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ';', ',':
+			if !quoted {
+				return s[:i], s[i:]
+			}
+		}
+	}
+	return s, ""
+}
+
+// splitHeader splits a list of comma separated header values into its
+// individual, trimmed values.
+func splitHeader(values []string) []string {
+	var s []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				s = append(s, part)
+			}
+		}
+	}
+	return s
+}
+
+// stripPort removes the port, and any brackets, from an address such as
+// "192.0.2.1:80" or "[2001:db8::1]:80".
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
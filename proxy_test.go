@@ -0,0 +1,198 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRouterTrustedProxies(t *testing.T) {
+	router := NewRouter()
+
+	// The default state is empty.
+	if len(router.TrustedProxies()) != 0 {
+		t.Errorf("Expected no trusted proxies, received '%v'.", router.TrustedProxies())
+	}
+
+	// Bare addresses are treated as single host networks.
+	proxies := []string{"10.0.0.0/8", "192.0.2.1/32", "2001:db8::1/128"}
+	router.SetTrustedProxies("10.0.0.0/8", "192.0.2.1", "2001:db8::1")
+	if router.Error() != nil {
+		t.Errorf("Expected no error, received '%v'.", router.Error())
+	}
+	if !slicesAreSimilar(proxies, router.TrustedProxies()) {
+		t.Errorf("Expected trusted proxies '%v' to be similar to '%v'.", router.TrustedProxies(), proxies)
+	}
+
+	// Invalid proxies are an error.
+	for _, p := range []string{"", "example.com", "10.0.0.0/33"} {
+		router.UnsetError()
+		router.SetTrustedProxies(p)
+		if router.Error() == nil {
+			t.Errorf("%v: Expected an error, received none.", p)
+		}
+		if !slicesAreSimilar(proxies, router.TrustedProxies()) {
+			t.Errorf("%v: Expected trusted proxies '%v' to be unchanged, received '%v'.", p, proxies, router.TrustedProxies())
+		}
+	}
+
+	// Trusted proxies can be unset.
+	router.UnsetTrustedProxies()
+	if len(router.TrustedProxies()) != 0 {
+		t.Errorf("Expected no trusted proxies, received '%v'.", router.TrustedProxies())
+	}
+}
+
+func TestRouterForwarded(t *testing.T) {
+	type forwardedTest struct {
+		remoteAddr string
+		headers    http.Header
+		scheme     string
+		host       string
+		clientIP   string
+	}
+
+	requests := []forwardedTest{
+		{ // 0
+			// Requests from untrusted addresses are taken at face value.
+			remoteAddr: "198.51.100.1:1234",
+			headers: http.Header{
+				"Forwarded": {"for=203.0.113.1;proto=https;host=example.com"},
+			},
+			scheme:   "http",
+			host:     "internal.local",
+			clientIP: "198.51.100.1",
+		},
+		{ // 1
+			remoteAddr: "10.0.0.1:1234",
+			headers: http.Header{
+				"Forwarded": {"for=203.0.113.1;proto=https;host=example.com"},
+			},
+			scheme:   "https",
+			host:     "example.com",
+			clientIP: "203.0.113.1",
+		},
+		{ // 2
+			// Elements added on behalf of trusted proxies are skipped, but
+			// elements added by the client are not trusted.
+			remoteAddr: "10.0.0.1:1234",
+			headers: http.Header{
+				"Forwarded": {
+					`for=192.0.2.1;proto=http;host=forged.example.com, for="[2001:db8::1]:4711";proto=https;host="example.com"`,
+					"for=10.0.0.2;proto=http;host=internal.local",
+				},
+			},
+			scheme:   "https",
+			host:     "example.com",
+			clientIP: "2001:db8::1",
+		},
+		{ // 3
+			remoteAddr: "10.0.0.1:1234",
+			headers: http.Header{
+				"X-Forwarded-For":   {"192.0.2.1, 203.0.113.1, 10.0.0.2"},
+				"X-Forwarded-Proto": {"HTTPS"},
+				"X-Forwarded-Host":  {"example.com"},
+			},
+			scheme:   "https",
+			host:     "example.com",
+			clientIP: "203.0.113.1",
+		},
+		{ // 4
+			// Forwarded takes precedence over X-Forwarded-*.
+			remoteAddr: "10.0.0.1:1234",
+			headers: http.Header{
+				"Forwarded":         {"for=203.0.113.1;host=example.com"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			scheme:   "http",
+			host:     "example.com",
+			clientIP: "203.0.113.1",
+		},
+		{ // 5
+			// Invalid values are ignored.
+			remoteAddr: "10.0.0.1:1234",
+			headers: http.Header{
+				"X-Forwarded-For":   {"unknown"},
+				"X-Forwarded-Proto": {"gopher"},
+				"X-Forwarded-Host":  {"example.com/path"},
+			},
+			scheme:   "http",
+			host:     "internal.local",
+			clientIP: "10.0.0.1",
+		},
+	}
+
+	var scheme, host, clientIP string
+	router := NewRouter().SetTrustedProxies("10.0.0.0/8")
+	router.NewRoute().SetHandler(func(w http.ResponseWriter, r *Request) {
+		scheme, host, clientIP = r.Scheme(), r.Host(), r.ClientIP()
+	})
+
+	for pos, r := range requests {
+		request := httptest.NewRequest("GET", "http://internal.local/", nil)
+		request.RemoteAddr = r.remoteAddr
+		request.Header = r.headers
+		router.ServeHTTP(httptest.NewRecorder(), request)
+		if scheme != r.scheme {
+			t.Errorf("requests[%v]: Expected scheme '%v', received '%v'.", pos, r.scheme, scheme)
+		}
+		if host != r.host {
+			t.Errorf("requests[%v]: Expected host '%v', received '%v'.", pos, r.host, host)
+		}
+		if clientIP != r.clientIP {
+			t.Errorf("requests[%v]: Expected client IP '%v', received '%v'.", pos, r.clientIP, clientIP)
+		}
+	}
+}
+
+func TestRouterForwarded_match(t *testing.T) {
+	var matched bool
+	var absolute string
+	router := NewRouter().SetTrustedProxies("10.0.0.0/8")
+	route := router.NewRoute().SetSchemes("https").SetHost("example.com").Get("/users/{id:[0-9]+}")
+	route.SetHandler(func(w http.ResponseWriter, r *Request) {
+		matched = true
+		u, _ := url.Parse("/users/1")
+		absolute = r.AbsoluteURL(u).String()
+	})
+
+	request := httptest.NewRequest("GET", "http://internal.local/users/1", nil)
+	request.Header.Set("X-Forwarded-Proto", "https")
+	request.Header.Set("X-Forwarded-Host", "example.com")
+
+	// Without a trusted proxy, the route does not match.
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	if matched {
+		t.Error("Expected route to not match.")
+	}
+
+	request.RemoteAddr = "10.1.2.3:1234"
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	if !matched {
+		t.Error("Expected route to match.")
+	}
+	if absolute != "https://example.com/users/1" {
+		t.Errorf("Expected URL 'https://example.com/users/1', received '%v'.", absolute)
+	}
+}
+
+func TestHelper_parseForwarded(t *testing.T) {
+	values := []string{
+		`for=192.0.2.43, for="[2001:db8:cafe::17]:4711";proto=https`,
+		`For="_gazonk";by=203.0.113.43;host="a;b,c"`,
+	}
+	expected := []map[string]string{
+		{"for": "192.0.2.43"},
+		{"for": "[2001:db8:cafe::17]:4711", "proto": "https"},
+		{"for": "_gazonk", "by": "203.0.113.43", "host": "a;b,c"},
+	}
+	if elements := parseForwarded(values); !reflect.DeepEqual(elements, expected) {
+		t.Errorf("Expected elements '%v', received '%v'.", expected, elements)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Error messages related to rate limiting.
+const (
+	errInvalidRateLimit = "routing: Rate limit must have a positive rate and burst."
+)
+
+// rateLimitSweepInterval is how often a MemoryRateLimitStore evicts buckets
+// that have refilled.
+const rateLimitSweepInterval = time.Minute
+
+// A RateLimitKey returns the key that a request is rate limited by.  Requests
+// that share a key share a token bucket.
+type RateLimitKey func(*Request) string
+
+// ClientIPKey rate limits requests by the IP address of the client.
+func ClientIPKey(req *Request) string {
+	return req.ClientIP()
+}
+
+// HeaderKey returns a RateLimitKey that rate limits requests by the value of
+// the header named h, such as an API key.  Requests without the header share
+// a single bucket.
+func HeaderKey(h string) RateLimitKey {
+	return func(req *Request) string {
+		return req.Request.Header.Get(h)
+	}
+}
+
+// ParamKey returns a RateLimitKey that rate limits requests by the value of
+// the route parameter named p.
+func ParamKey(p string) RateLimitKey {
+	return func(req *Request) string {
+		return req.Params[p]
+	}
+}
+
+// A RateLimitStore holds the token buckets used for rate limiting.
+// Implementations must be safe for concurrent use.
+type RateLimitStore interface {
+	// Take removes a token from the bucket identified by key, which holds up
+	// to burst tokens and refills at rate tokens per second.  A new bucket
+	// starts out full.  Take returns the number of tokens left in the bucket,
+	// and if the bucket was empty, how long until a token is available.
+	Take(key string, rate float64, burst int, now time.Time) (remaining int, wait time.Duration, err error)
+}
+
+// rateLimit holds the rate limiting settings of a route.
+type rateLimit struct {
+	rate  float64
+	burst int
+	key   RateLimitKey
+}
+
+// SetRateLimitStore sets the store that holds the token buckets used for rate
+// limiting.  By default, each router uses its own MemoryRateLimitStore.
+func (r *Router) SetRateLimitStore(s RateLimitStore) *Router {
+	r.rateLimitStore = s
+	return r
+}
+
+// RateLimitStore returns the store that holds the token buckets used for rate
+// limiting.
+func (r *Router) RateLimitStore() RateLimitStore {
+	return r.rateLimitStore
+}
+
+// SetRateLimit limits the rate of requests that the route's handler will
+// accept.  Requests are grouped by key, which defaults to ClientIPKey if nil,
+// and each group has a bucket of up to burst tokens that refills at rate
+// tokens per second.  Each request takes a token, and requests made while the
+// bucket is empty receive a 429 Too Many Requests response with a Retry-After
+// header.  Every response includes RateLimit-Limit, RateLimit-Remaining, and
+// RateLimit-Reset headers.  If rate or burst is not positive, no rate limit
+// is set, and an error message is set on the route.
+//
+// Buckets are shared by routes with the same name, or if unnamed, the same
+// host and path.  If the router's store returns an error, the request is
+// allowed.  In a Subroute tree, the rate limit of the last matched route
+// applies.
+func (r *Route) SetRateLimit(rate float64, burst int, key RateLimitKey) *Route {
+	if rate <= 0 || burst <= 0 {
+		r.err = fmt.Errorf(errInvalidRateLimit)
+		return r
+	}
+	if key == nil {
+		key = ClientIPKey
+	}
+	r.rateLimit = &rateLimit{
+		rate:  rate,
+		burst: burst,
+		key:   key,
+	}
+	return r
+}
+
+// RateLimit returns the rate and burst of the route's rate limit.
+func (r *Route) RateLimit() (float64, int) {
+	if r.rateLimit == nil {
+		return 0, 0
+	}
+	return r.rateLimit.rate, r.rateLimit.burst
+}
+
+// UnsetRateLimit clears the route's rate limit.
+func (r *Route) UnsetRateLimit() {
+	r.rateLimit = nil
+}
+
+// withRateLimit returns h wrapped so that requests are limited by route's
+// rate limit.
+func (r *Router) withRateLimit(route *Route, h HandlerFunc) HandlerFunc {
+	limit := route.rateLimit
+	return func(w http.ResponseWriter, req *Request) {
+		if r.rateLimitStore == nil {
+			h(w, req)
+			return
+		}
+		key := route.rateLimitID() + "\x00" + limit.key(req)
+		remaining, wait, err := r.rateLimitStore.Take(key, limit.rate, limit.burst, time.Now())
+		if err != nil {
+			h(w, req)
+			return
+		}
+
+		reset := math.Ceil(float64(limit.burst-remaining) / limit.rate)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.FormatFloat(reset, 'f', 0, 64))
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.FormatFloat(math.Ceil(wait.Seconds()), 'f', 0, 64))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		h(w, req)
+	}
+}
+
+// rateLimitID returns the string that identifies the route's buckets.
+func (r *Route) rateLimitID() string {
+	if name := r.Name(); name != "" {
+		return "name:" + name
+	}
+	id := "route:"
+	if r.host != nil {
+		id += r.host.rawHost
+	}
+	if r.path != nil {
+		id += r.path.rawPath
+	}
+	return id
+}
+
+// A MemoryRateLimitStore is a RateLimitStore that holds its buckets in
+// memory.  Buckets are evicted once they have refilled.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket is a single bucket in a MemoryRateLimitStore.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // When the bucket will have refilled
+}
+
+// NewMemoryRateLimitStore returns a new MemoryRateLimitStore.
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Take removes a token from the bucket identified by key.
+func (s *MemoryRateLimitStore) Take(key string, rate float64, burst int, now time.Time) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= rateLimitSweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), updated: now}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(burst), b.tokens+elapsed.Seconds()*rate)
+		b.updated = now
+	}
+
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	} else {
+		b.tokens--
+	}
+	b.full = now.Add(time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second)))
+	return int(b.tokens), wait, nil
+}
+
+// sweep evicts buckets that have refilled.  s.mu must be held.
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if !b.full.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,250 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sharedRateLimitStore stands in for a store shared by several servers.  It
+// counts the calls made to it, and can be made to fail.
+type sharedRateLimitStore struct {
+	mu    sync.Mutex
+	store *MemoryRateLimitStore
+	calls int
+	fail  bool
+}
+
+func (s *sharedRateLimitStore) Take(key string, rate float64, burst int, now time.Time) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.fail {
+		return 0, 0, errors.New("store unavailable")
+	}
+	return s.store.Take(key, rate, burst, now)
+}
+
+func TestRouteRateLimit(t *testing.T) {
+	router := NewRouter()
+	route := router.NewRoute()
+
+	if rate, burst := route.RateLimit(); rate != 0 || burst != 0 {
+		t.Errorf("Expected no rate limit, received '%v', '%v'.", rate, burst)
+	}
+
+	route.SetRateLimit(0, 1, nil)
+	if route.Error() == nil {
+		t.Error("Expected an error, received none.")
+	}
+	route.UnsetError()
+	route.SetRateLimit(1, 0, nil)
+	if route.Error() == nil {
+		t.Error("Expected an error, received none.")
+	}
+
+	route.UnsetError()
+	route.SetRateLimit(2.5, 10, nil)
+	if route.Error() != nil {
+		t.Errorf("Expected no error, received '%v'.", route.Error())
+	}
+	if rate, burst := route.RateLimit(); rate != 2.5 || burst != 10 {
+		t.Errorf("Expected rate limit '2.5', '10', received '%v', '%v'.", rate, burst)
+	}
+
+	// Subroutes inherit the rate limit.
+	if rate, burst := route.Subroute().RateLimit(); rate != 2.5 || burst != 10 {
+		t.Errorf("Expected rate limit '2.5', '10', received '%v', '%v'.", rate, burst)
+	}
+
+	route.UnsetRateLimit()
+	if rate, burst := route.RateLimit(); rate != 0 || burst != 0 {
+		t.Errorf("Expected no rate limit, received '%v', '%v'.", rate, burst)
+	}
+}
+
+func TestRouteRateLimit_request(t *testing.T) {
+	router := NewRouter()
+	handler := func(w http.ResponseWriter, req *Request) {}
+	router.NewRoute().SetPath("/ip").SetRateLimit(1, 2, nil).SetHandler(handler)
+	router.NewRoute().SetPath("/key").SetRateLimit(1, 1, HeaderKey("X-Api-Key")).SetHandler(handler)
+	router.NewRoute().SetPath("/users/{id:[0-9]+}").SetRateLimit(1, 1, ParamKey("id")).SetHandler(handler)
+
+	type rateLimitTest struct {
+		path       string
+		remoteAddr string
+		apiKey     string
+		code       int
+		remaining  string
+		retryAfter string
+	}
+
+	tests := []rateLimitTest{
+		{ // 0
+			path:       "/ip",
+			remoteAddr: "192.0.2.1:1234",
+			code:       http.StatusOK,
+			remaining:  "1",
+		},
+		{ // 1
+			path:       "/ip",
+			remoteAddr: "192.0.2.1:1234",
+			code:       http.StatusOK,
+			remaining:  "0",
+		},
+		{ // 2
+			path:       "/ip",
+			remoteAddr: "192.0.2.1:5678",
+			code:       http.StatusTooManyRequests,
+			remaining:  "0",
+			retryAfter: "1",
+		},
+		{ // 3
+			// Other clients have their own bucket.
+			path:       "/ip",
+			remoteAddr: "192.0.2.2:1234",
+			code:       http.StatusOK,
+			remaining:  "1",
+		},
+		{ // 4
+			path:       "/key",
+			remoteAddr: "192.0.2.1:1234",
+			apiKey:     "abc",
+			code:       http.StatusOK,
+			remaining:  "0",
+		},
+		{ // 5
+			path:       "/key",
+			remoteAddr: "192.0.2.2:1234",
+			apiKey:     "abc",
+			code:       http.StatusTooManyRequests,
+			remaining:  "0",
+			retryAfter: "1",
+		},
+		{ // 6
+			path:       "/key",
+			remoteAddr: "192.0.2.1:1234",
+			apiKey:     "def",
+			code:       http.StatusOK,
+			remaining:  "0",
+		},
+		{ // 7
+			path:       "/users/1",
+			remoteAddr: "192.0.2.1:1234",
+			code:       http.StatusOK,
+			remaining:  "0",
+		},
+		{ // 8
+			path:       "/users/1",
+			remoteAddr: "192.0.2.2:1234",
+			code:       http.StatusTooManyRequests,
+			remaining:  "0",
+			retryAfter: "1",
+		},
+		{ // 9
+			path:       "/users/2",
+			remoteAddr: "192.0.2.1:1234",
+			code:       http.StatusOK,
+			remaining:  "0",
+		},
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com"+test.path, nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.apiKey != "" {
+			req.Header.Set("X-Api-Key", test.apiKey)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("tests[%v]: Expected code '%v', received '%v'.", i, test.code, w.Code)
+		}
+		if w.Header().Get("RateLimit-Remaining") != test.remaining {
+			t.Errorf("tests[%v]: Expected remaining '%v', received '%v'.", i, test.remaining, w.Header().Get("RateLimit-Remaining"))
+		}
+		if w.Header().Get("Retry-After") != test.retryAfter {
+			t.Errorf("tests[%v]: Expected retry after '%v', received '%v'.", i, test.retryAfter, w.Header().Get("Retry-After"))
+		}
+		if w.Header().Get("RateLimit-Limit") == "" || w.Header().Get("RateLimit-Reset") == "" {
+			t.Errorf("tests[%v]: Expected RateLimit headers, received '%v'.", i, w.Header())
+		}
+	}
+}
+
+func TestRouterRateLimitStore(t *testing.T) {
+	// Two routers sharing a store share their buckets.
+	store := &sharedRateLimitStore{store: NewMemoryRateLimitStore()}
+	routers := []*Router{NewRouter(), NewRouter()}
+	for _, router := range routers {
+		router.SetRateLimitStore(store)
+		router.NewRoute().SetPath("/").SetRateLimit(1, 1, nil).SetHandler(func(w http.ResponseWriter, req *Request) {})
+	}
+
+	codes := []int{http.StatusOK, http.StatusTooManyRequests}
+	for i, router := range routers {
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != codes[i] {
+			t.Errorf("routers[%v]: Expected code '%v', received '%v'.", i, codes[i], w.Code)
+		}
+	}
+	if store.calls != 2 {
+		t.Errorf("Expected 2 calls to the store, received '%v'.", store.calls)
+	}
+
+	// Requests are allowed when the store fails.
+	store.fail = true
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	routers[0].ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected code '200', received '%v'.", w.Code)
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	now := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, expected := range []int{2, 1, 0} {
+		remaining, wait, err := store.Take("a", 2, 3, now)
+		if err != nil {
+			t.Errorf("%v: Expected no error, received '%v'.", i, err)
+		}
+		if remaining != expected || wait != 0 {
+			t.Errorf("%v: Expected '%v' remaining and no wait, received '%v' and '%v'.", i, expected, remaining, wait)
+		}
+	}
+	if _, wait, _ := store.Take("a", 2, 3, now); wait != 500*time.Millisecond {
+		t.Errorf("Expected a wait of '500ms', received '%v'.", wait)
+	}
+
+	// The bucket refills over time.
+	if remaining, wait, _ := store.Take("a", 2, 3, now.Add(time.Second)); remaining != 1 || wait != 0 {
+		t.Errorf("Expected '1' remaining and no wait, received '%v' and '%v'.", remaining, wait)
+	}
+
+	// Buckets that have refilled are evicted.
+	store.Take("b", 0.001, 3, now.Add(time.Second))
+	if len(store.buckets) != 2 {
+		t.Errorf("Expected 2 buckets, received '%v'.", len(store.buckets))
+	}
+	store.Take("c", 1, 3, now.Add(rateLimitSweepInterval))
+	if _, ok := store.buckets["a"]; ok {
+		t.Error("Expected bucket 'a' to be evicted.")
+	}
+	if _, ok := store.buckets["b"]; !ok {
+		t.Error("Expected bucket 'b' to be kept.")
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Error messages related to redirects.
+const (
+	errInvalidRedirectCode   = "routing: %d is not a valid redirect status code."
+	errInvalidRedirectTarget = "routing: '%s' is not a valid redirect target."
+	errRedirectParam         = "routing: Redirect target parameter '%s' is not captured by the route."
+	errRedirectEntry         = "routing: Redirect entry %d: %s"
+	errRedirectFields        = "routing: Redirect entry %d has %d fields, expected 2 or 3."
+)
+
+// redirectParam matches a parameter reference in a redirect target.
+var redirectParam = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// A redirect holds a parsed redirect target.
+type redirect struct {
+	route    *Route            // Named route to redirect to, if any
+	params   map[string]string // Parameter templates for route
+	target   string            // Template of the target, without its query
+	query    string            // Template of the query
+	hasQuery bool              // Whether the target replaces the query
+	code     int
+}
+
+// Redirect sets the route to redirect requests to target with the given
+// status code, which must be one of 301, 302, 303, 307, or 308.  The target is
+// either a path or an absolute URL, which can reference parameters captured by
+// the route, such as "/posts/{year}/{slug}", or "@" followed by the name of
+// another route, such as "@post", whose parameters are filled in with the
+// captured parameters of the same name.  Paths are relative to the router, so
+// the path of any mount point is prepended.
+//
+// If the target has no query string, the request's query string is kept.
+// Otherwise, the request's query string is replaced by the target's, which
+// can also reference parameters, and which can be empty, such as "/search?",
+// to drop the query string.
+//
+// If the target is not valid, references parameters that the route does not
+// capture, or names a route that is not defined, an error is set on the
+// route, and no handler is set.  The route's path and host must therefore be
+// set first.
+func (r *Route) Redirect(target string, code int) *Route {
+	if strings.HasPrefix(target, "@") {
+		name, query, hasQuery := strings.Cut(target[1:], "?")
+		return r.redirectRoute(name, nil, query, hasQuery, code)
+	}
+	rd, err := r.newRedirect(target, code)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.handler = rd.serve
+	return r
+}
+
+// RedirectRoute sets the route to redirect requests to the route named by
+// name, with the given status code.  The named route's parameters are filled
+// in with the captured parameters of the same name, and with params, whose
+// values can reference captured parameters, such as {"id": "{slug}"}.  The
+// request's query string is kept.  Errors are handled in the same way as
+// Redirect.
+func (r *Route) RedirectRoute(name string, params map[string]string, code int) *Route {
+	return r.redirectRoute(name, params, "", false, code)
+}
+
+// redirectRoute sets the route to redirect requests to a named route.
+func (r *Route) redirectRoute(name string, params map[string]string, query string, hasQuery bool, code int) *Route {
+	route, err := r.router.Route(name)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	rd, err := r.newRedirect("?"+query, code)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	for k, v := range params {
+		if err := r.validateRedirectTemplate(v); err != nil {
+			r.err = err
+			return r
+		}
+		// Parameters must be known to the named route.
+		if !pathHasParam(route.path, k) {
+			r.err = fmt.Errorf(errRedirectParam, k)
+			return r
+		}
+	}
+	if route.path != nil {
+		for _, param := range route.path.params {
+			if _, ok := params[param[0]]; !ok && !r.capturesParam(param[0]) {
+				r.err = fmt.Errorf(errRedirectParam, param[0])
+				return r
+			}
+		}
+	}
+	rd.route, rd.params, rd.hasQuery = route, params, hasQuery
+	r.handler = rd.serve
+	return r
+}
+
+// newRedirect parses and validates target.
+func (r *Route) newRedirect(target string, code int) (*redirect, error) {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf(errInvalidRedirectCode, code)
+	}
+	if target == "" {
+		return nil, fmt.Errorf(errInvalidRedirectTarget, target)
+	}
+	if err := r.validateRedirectTemplate(target); err != nil {
+		return nil, err
+	}
+	if _, err := url.Parse(redirectParam.ReplaceAllString(target, "$1")); err != nil {
+		return nil, fmt.Errorf(errInvalidRedirectTarget, target)
+	}
+	rd := &redirect{code: code}
+	rd.target, rd.query, rd.hasQuery = strings.Cut(target, "?")
+	return rd, nil
+}
+
+// validateRedirectTemplate checks that the braces in s are balanced, and that
+// every parameter it references is captured by the route.
+func (r *Route) validateRedirectTemplate(s string) error {
+	if strings.Count(s, "{") != strings.Count(s, "}") {
+		return fmt.Errorf(errInvalidRedirectTarget, s)
+	}
+	for _, m := range redirectParam.FindAllStringSubmatch(s, -1) {
+		if m[1] == "" {
+			return fmt.Errorf(errInvalidRedirectTarget, s)
+		}
+		if !r.capturesParam(m[1]) {
+			return fmt.Errorf(errRedirectParam, m[1])
+		}
+	}
+	return nil
+}
+
+// capturesParam returns true if the route's host or path captures the named
+// parameter.
+func (r *Route) capturesParam(name string) bool {
+	if pathHasParam(r.path, name) {
+		return true
+	}
+	if r.host != nil {
+		for _, re := range []*regexp.Regexp{r.host.pattern, r.host.portPattern} {
+			if re != nil && sliceContainsString(re.SubexpNames(), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pathHasParam returns true if p has a parameter with the given name.
+func pathHasParam(p *pathInfo, name string) bool {
+	if p == nil {
+		return false
+	}
+	for _, param := range p.params {
+		if param[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// serve redirects the request.
+func (rd *redirect) serve(w http.ResponseWriter, req *Request) {
+	var location string
+	if rd.route != nil {
+		params := make(map[string]string, len(req.Params)+len(rd.params))
+		for k, v := range req.Params {
+			params[k] = v
+		}
+		for k, v := range rd.params {
+			params[k] = expandRedirect(v, req.Params, nil)
+		}
+		u, err := rd.route.URL(params)
+		if err != nil {
+			// The captured values can not be used by the named route.
+			http.NotFound(w, req.Request)
+			return
+		}
+		location = u.String()
+	} else {
+		location = expandRedirect(rd.target, req.Params, escapePathValue)
+		if strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") {
+			location = mountPrefix(req.Request) + location
+		}
+	}
+
+	if rd.hasQuery {
+		if query := expandRedirect(rd.query, req.Params, url.QueryEscape); query != "" {
+			location += "?" + query
+		}
+	} else if req.Request.URL.RawQuery != "" {
+		location += "?" + req.Request.URL.RawQuery
+	}
+	http.Redirect(w, req.Request, location, rd.code)
+}
+
+// expandRedirect replaces the parameter references in s with the values in
+// params, escaped with escape if it is not nil.
+func expandRedirect(s string, params map[string]string, escape func(string) string) string {
+	return redirectParam.ReplaceAllStringFunc(s, func(ref string) string {
+		v := params[ref[1:len(ref)-1]]
+		if escape != nil {
+			v = escape(v)
+		}
+		return v
+	})
+}
+
+// A RedirectEntry is a single redirect loaded by LoadRedirects.
+type RedirectEntry struct {
+	// From is the path to redirect, which can capture parameters.
+	From string `json:"from"`
+	// To is the redirect target, as accepted by Route.Redirect.
+	To string `json:"to"`
+	// Code is the status code of the redirect.  If it is zero, 301 is used.
+	Code int `json:"code,omitempty"`
+}
+
+// LoadRedirects adds a route for each entry that redirects requests for
+// entry.From to entry.To.  Every entry is validated before any route is
+// added, so if an error is returned, which identifies the first invalid entry,
+// the router is left unchanged.
+func (r *Router) LoadRedirects(entries []RedirectEntry) error {
+	n := len(r.routes)
+	for i, entry := range entries {
+		code := entry.Code
+		if code == 0 {
+			code = http.StatusMovedPermanently
+		}
+		route := r.NewRoute().SetPath(entry.From).Redirect(entry.To, code)
+		if err := route.Error(); err != nil {
+			r.routes = r.routes[:n]
+			return fmt.Errorf(errRedirectEntry, i+1, strings.TrimPrefix(err.Error(), "routing: "))
+		}
+	}
+	return nil
+}
+
+// LoadRedirectsJSON reads a JSON array of redirect entries, each an object
+// with "from", "to", and optionally "code" fields, and adds them with
+// LoadRedirects.
+func (r *Router) LoadRedirectsJSON(rd io.Reader) error {
+	var entries []RedirectEntry
+	if err := json.NewDecoder(rd).Decode(&entries); err != nil {
+		return err
+	}
+	return r.LoadRedirects(entries)
+}
+
+// LoadRedirectsCSV reads redirect entries from CSV records of the form
+// "from,to" or "from,to,code", and adds them with LoadRedirects.  A first
+// record of "from,to" or "from,to,code" is treated as a header and skipped.
+func (r *Router) LoadRedirectsCSV(rd io.Reader) error {
+	reader := csv.NewReader(rd)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) > 0 && len(records[0]) >= 2 && records[0][0] == "from" && records[0][1] == "to" {
+		records = records[1:]
+	}
+
+	entries := make([]RedirectEntry, len(records))
+	for i, record := range records {
+		if len(record) < 2 || len(record) > 3 {
+			return fmt.Errorf(errRedirectFields, i+1, len(record))
+		}
+		entries[i] = RedirectEntry{From: record[0], To: record[1]}
+		if len(record) == 3 && record[2] != "" {
+			if entries[i].Code, err = strconv.Atoi(record[2]); err != nil {
+				return fmt.Errorf(errRedirectEntry, i+1, err.Error())
+			}
+		}
+	}
+	return r.LoadRedirects(entries)
+}
@@ -0,0 +1,157 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouteRedirect(t *testing.T) {
+	handler := func(w http.ResponseWriter, req *Request) {}
+	router := NewRouter()
+	router.NewRoute().SetName("post").SetPath("/posts/{year:[0-9]+}/{slug}").SetHandler(handler)
+	router.NewRoute().SetName("search").SetPath("/search").SetHandler(handler)
+
+	router.NewRoute().SetPath("/blog/{year:[0-9]+}/{slug}").Redirect("/posts/{year}/{slug}", http.StatusMovedPermanently)
+	router.NewRoute().SetPath("/archive/{year:[0-9]+}/{slug}").Redirect("@post", http.StatusFound)
+	router.NewRoute().SetPath("/old/{title}").RedirectRoute("post", map[string]string{"year": "2013", "slug": "{title}"}, http.StatusPermanentRedirect)
+	router.NewRoute().SetPath("/find/{term}").Redirect("/search?q={term}", http.StatusSeeOther)
+	router.NewRoute().SetPath("/lookup").Redirect("@search?", http.StatusMovedPermanently)
+	router.NewRoute().SetPath("/files/{path:*}").Redirect("https://cdn.example.com/{path}", http.StatusTemporaryRedirect)
+
+	type redirectTest struct {
+		path     string
+		code     int
+		location string
+	}
+
+	tests := []redirectTest{
+		{"/blog/2013/hello", http.StatusMovedPermanently, "/posts/2013/hello"},                        // 0
+		{"/blog/2013/hello?ref=feed", http.StatusMovedPermanently, "/posts/2013/hello?ref=feed"},      // 1
+		{"/archive/2012/old-news", http.StatusFound, "/posts/2012/old-news"},                          // 2
+		{"/old/first-post?a=1", http.StatusPermanentRedirect, "/posts/2013/first-post?a=1"},           // 3
+		{"/find/go%20routing?page=2", http.StatusSeeOther, "/search?q=go+routing"},                    // 4
+		{"/lookup?q=old", http.StatusMovedPermanently, "/search"},                                     // 5
+		{"/files/img/a b.png", http.StatusTemporaryRedirect, "https://cdn.example.com/img/a%20b.png"}, // 6
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com"+test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("tests[%v]: Expected code '%v', received '%v'.", i, test.code, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("tests[%v]: Expected location '%v', received '%v'.", i, test.location, location)
+		}
+	}
+
+	// Mounted routers prepend the mount point.
+	api := NewRouter()
+	api.NewRoute().SetPath("/v1/{id}").Redirect("/v2/{id}", http.StatusMovedPermanently)
+	router.Mount("/api", api)
+	req, _ := http.NewRequest("GET", "http://example.com/api/v1/7", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if location := w.Header().Get("Location"); location != "/api/v2/7" {
+		t.Errorf("Expected location '/api/v2/7', received '%v'.", location)
+	}
+}
+
+func TestRouteRedirectErrors(t *testing.T) {
+	router := NewRouter()
+	router.NewRoute().SetName("post").SetPath("/posts/{year:[0-9]+}/{slug}")
+
+	type errorTest struct {
+		path   string
+		target string
+		code   int
+	}
+
+	tests := []errorTest{
+		{"/a", "/b", http.StatusOK},                       // 0
+		{"/a", "", http.StatusMovedPermanently},           // 1
+		{"/a/{id}", "/b/{slug}", http.StatusFound},        // 2
+		{"/a/{id}", "/b/{id", http.StatusFound},           // 3
+		{"/a/{id}", "/b/{}", http.StatusFound},            // 4
+		{"/a/{slug}", "@post", http.StatusFound},          // 5
+		{"/a", "@missing", http.StatusFound},              // 6
+		{"/a/{id}", "http://[::1/{id}", http.StatusFound}, // 7
+	}
+
+	for i, test := range tests {
+		route := router.NewRoute().SetPath(test.path).Redirect(test.target, test.code)
+		if route.Error() == nil {
+			t.Errorf("tests[%v]: Expected an error, received none.", i)
+		}
+		if route.handler != nil {
+			t.Errorf("tests[%v]: Expected no handler to be set.", i)
+		}
+	}
+
+	route := router.NewRoute().SetPath("/a/{title}").RedirectRoute("post", map[string]string{"year": "2013", "page": "{title}"}, http.StatusFound)
+	if route.Error() == nil {
+		t.Error("Expected an error for a parameter unknown to the named route, received none.")
+	}
+}
+
+func TestRouterLoadRedirects(t *testing.T) {
+	router := NewRouter()
+	router.NewRoute().SetName("post").SetPath("/posts/{slug}").SetHandler(func(w http.ResponseWriter, req *Request) {})
+
+	csvMap := "from,to,code\n# Legacy blog\n/blog/{slug},@post\n/about-us,/about,308\n"
+	if err := router.LoadRedirectsCSV(strings.NewReader(csvMap)); err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	jsonMap := `[{"from": "/contact-us", "to": "/contact?via=legacy", "code": 302}]`
+	if err := router.LoadRedirectsJSON(strings.NewReader(jsonMap)); err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+
+	type loadTest struct {
+		path     string
+		code     int
+		location string
+	}
+
+	tests := []loadTest{
+		{"/blog/hello", http.StatusMovedPermanently, "/posts/hello"}, // 0
+		{"/about-us", http.StatusPermanentRedirect, "/about"},        // 1
+		{"/contact-us", http.StatusFound, "/contact?via=legacy"},     // 2
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com"+test.path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.code || w.Header().Get("Location") != test.location {
+			t.Errorf("tests[%v]: Expected '%v' to '%v', received '%v' to '%v'.", i, test.code, test.location, w.Code, w.Header().Get("Location"))
+		}
+	}
+
+	// Invalid maps are rejected as a whole.
+	routes := len(router.routes)
+	invalid := []string{
+		"/a,/b\n/c/{id},/d/{slug}\n",
+		"/a,/b,abc\n",
+		"/a\n",
+		"/a,/b,200\n",
+	}
+	for i, m := range invalid {
+		if err := router.LoadRedirectsCSV(strings.NewReader(m)); err == nil {
+			t.Errorf("invalid[%v]: Expected an error, received none.", i)
+		}
+	}
+	if err := router.LoadRedirectsJSON(strings.NewReader(`[{"from": "/a", "to": "@missing"}]`)); err == nil || !strings.Contains(err.Error(), "entry 1") {
+		t.Errorf("Expected an error for entry 1, received '%v'.", err)
+	}
+	if len(router.routes) != routes {
+		t.Errorf("Expected '%v' routes, received '%v'.", routes, len(router.routes))
+	}
+}
@@ -0,0 +1,489 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Error messages related to reverse proxies.
+const (
+	errNoUpstreams     = "routing: A proxy needs at least one upstream."
+	errInvalidUpstream = "routing: '%s' is not a valid upstream URL."
+)
+
+// proxyKey is the context key under which the proxied request is stored
+// while it is forwarded.
+type proxyKey struct{}
+
+// proxied holds the details of a request that is being forwarded.
+type proxied struct {
+	req      *Request
+	upstream *Upstream
+}
+
+// An Upstream is a backend server that a Proxy forwards requests to.
+type Upstream struct {
+	url    *url.URL
+	active int64 // Number of requests in flight, accessed atomically
+
+	mu           sync.Mutex
+	healthy      bool      // Result of the last active health check
+	fails        int       // Consecutive failed requests
+	ejectedUntil time.Time // End of the passive ejection, if any
+}
+
+// URL returns the upstream's URL.
+func (u *Upstream) URL() *url.URL {
+	return u.url
+}
+
+// ActiveRequests returns the number of requests currently being forwarded to
+// the upstream.
+func (u *Upstream) ActiveRequests() int64 {
+	return atomic.LoadInt64(&u.active)
+}
+
+// Available returns true if the upstream passed its last health check, and is
+// not ejected due to failed requests.
+func (u *Upstream) Available() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.healthy && !time.Now().Before(u.ejectedUntil)
+}
+
+// A Balancer picks the upstream that a request is forwarded to.
+type Balancer interface {
+	// Pick returns one of upstreams, which are all available, and of which
+	// there is at least one.
+	Pick(req *Request, upstreams []*Upstream) *Upstream
+}
+
+// roundRobin picks each upstream in turn.
+type roundRobin struct {
+	next uint64
+}
+
+// RoundRobin returns a Balancer that picks each available upstream in turn.
+func RoundRobin() Balancer {
+	return new(roundRobin)
+}
+
+func (b *roundRobin) Pick(req *Request, upstreams []*Upstream) *Upstream {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return upstreams[n%uint64(len(upstreams))]
+}
+
+// leastConnections picks the upstream with the fewest requests in flight.
+type leastConnections struct{}
+
+// LeastConnections returns a Balancer that picks the available upstream with
+// the fewest requests in flight, preferring earlier upstreams on a tie.
+func LeastConnections() Balancer {
+	return leastConnections{}
+}
+
+func (leastConnections) Pick(req *Request, upstreams []*Upstream) *Upstream {
+	best := upstreams[0]
+	for _, u := range upstreams[1:] {
+		if u.ActiveRequests() < best.ActiveRequests() {
+			best = u
+		}
+	}
+	return best
+}
+
+// consistentHash picks upstreams by rendezvous hashing of a parameter.
+type consistentHash struct {
+	param string
+}
+
+// ConsistentHash returns a Balancer that always picks the same upstream for
+// the same value of the named route parameter, or for the same client IP if
+// the parameter is empty.  When an upstream becomes unavailable, only the
+// values that were picking it move to other upstreams.
+func ConsistentHash(param string) Balancer {
+	return consistentHash{param: param}
+}
+
+func (b consistentHash) Pick(req *Request, upstreams []*Upstream) *Upstream {
+	key := req.Params[b.param]
+	if key == "" {
+		key = req.ClientIP()
+	}
+	var best *Upstream
+	var bestScore uint64
+	for _, u := range upstreams {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(u.url.String()))
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = u, score
+		}
+	}
+	return best
+}
+
+// A Proxy forwards requests to a pool of upstreams, as a reverse proxy.  The
+// X-Forwarded-For, X-Forwarded-Host, and X-Forwarded-Proto headers are set on
+// forwarded requests.  If the request came from a trusted proxy, the existing
+// X-Forwarded-For header is extended, and the host and scheme reported by the
+// trusted proxy are passed on.
+//
+// By default, requests are forwarded with their path unchanged, upstreams are
+// picked in turn, and all upstreams are considered available.  If no upstream
+// is available, the response is 503, and if the upstream can not be reached,
+// it is 502.
+type Proxy struct {
+	upstreams []*Upstream
+	balancer  Balancer
+	strip     string
+	rewrite   string
+	proxy     *httputil.ReverseProxy
+
+	healthMu       sync.Mutex // Guards the health check settings
+	healthPath     string
+	healthInterval time.Duration
+	stopHealth     chan struct{} // Closed to stop the health checks
+	healthDone     chan struct{} // Closed once the health checks have stopped
+
+	maxFails int
+	ejectFor time.Duration
+}
+
+// NewProxy returns a new Proxy that forwards requests to the given upstream
+// URLs, such as "http://10.0.0.1:8080".  Upstream URLs can have a path, which
+// is prepended to the path of forwarded requests.
+func NewProxy(upstreams ...string) (*Proxy, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf(errNoUpstreams)
+	}
+	p := &Proxy{
+		upstreams: make([]*Upstream, 0, len(upstreams)),
+		balancer:  RoundRobin(),
+	}
+	for _, v := range upstreams {
+		u, err := url.Parse(v)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf(errInvalidUpstream, v)
+		}
+		p.upstreams = append(p.upstreams, &Upstream{url: u, healthy: true})
+	}
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite:        p.rewriteRequest,
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.handleError,
+	}
+	return p, nil
+}
+
+// Upstreams returns the proxy's upstreams.
+func (p *Proxy) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// SetBalancer sets the Balancer that picks the upstream for each request.
+func (p *Proxy) SetBalancer(b Balancer) *Proxy {
+	p.balancer = b
+	return p
+}
+
+// Balancer returns the Balancer that picks the upstream for each request.
+func (p *Proxy) Balancer() Balancer {
+	return p.balancer
+}
+
+// SetStripPrefix sets a prefix that is removed from the path of forwarded
+// requests, so that "/api/users" is forwarded as "/users" if the prefix is
+// "/api".
+func (p *Proxy) SetStripPrefix(prefix string) *Proxy {
+	p.strip = strings.TrimSuffix(prefix, "/")
+	return p
+}
+
+// StripPrefix returns the prefix that is removed from the path of forwarded
+// requests.
+func (p *Proxy) StripPrefix() string {
+	return p.strip
+}
+
+// SetRewrite sets a template for the path of forwarded requests, which can
+// reference the route's parameters, such as "/v2/users/{id}".  A rewrite
+// takes the place of any prefix stripping.
+func (p *Proxy) SetRewrite(template string) *Proxy {
+	p.rewrite = template
+	return p
+}
+
+// Rewrite returns the template for the path of forwarded requests.
+func (p *Proxy) Rewrite() string {
+	return p.rewrite
+}
+
+// UnsetRewrite clears the template for the path of forwarded requests.
+func (p *Proxy) UnsetRewrite() {
+	p.rewrite = ""
+}
+
+// SetHealthCheck starts checking the health of every upstream at the given
+// interval, by requesting path from each.  An upstream is available as long
+// as its last check responded with a status code below 400 within the
+// interval.  Any previous health checks are stopped.
+func (p *Proxy) SetHealthCheck(path string, interval time.Duration) *Proxy {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+	p.stopHealthChecks()
+	p.healthPath, p.healthInterval = path, interval
+	if interval > 0 {
+		p.stopHealth, p.healthDone = make(chan struct{}), make(chan struct{})
+		go p.checkHealthEvery(path, interval, p.stopHealth, p.healthDone)
+	}
+	return p
+}
+
+// HealthCheck returns the path and interval of the health checks.
+func (p *Proxy) HealthCheck() (string, time.Duration) {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+	return p.healthPath, p.healthInterval
+}
+
+// UnsetHealthCheck stops checking the health of the upstreams, and considers
+// all of them healthy.  Any check in progress is cancelled, and does not
+// change the health of the upstreams.
+func (p *Proxy) UnsetHealthCheck() {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+	p.stopHealthChecks()
+	p.healthPath, p.healthInterval = "", 0
+	for _, u := range p.upstreams {
+		u.mu.Lock()
+		u.healthy = true
+		u.mu.Unlock()
+	}
+}
+
+// Close stops the proxy's health checks, and waits for any check in progress
+// to finish.  It should be called once a proxy with health checks is no
+// longer used.
+func (p *Proxy) Close() error {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+	p.stopHealthChecks()
+	return nil
+}
+
+// CheckHealth checks the health of every upstream once, and waits for the
+// checks to complete.  It is called at each health check interval, but can
+// also be called directly.
+func (p *Proxy) CheckHealth() {
+	path, interval := p.HealthCheck()
+	p.checkHealth(context.Background(), path, interval)
+}
+
+// checkHealth checks the health of every upstream by requesting path, with
+// the given timeout, and waits for the checks to complete.  The health of the
+// upstreams is left unchanged if ctx is cancelled.
+func (p *Proxy) checkHealth(ctx context.Context, path string, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+	var wg sync.WaitGroup
+	for _, u := range p.upstreams {
+		wg.Add(1)
+		go func(u *Upstream) {
+			defer wg.Done()
+			healthy := false
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.url.JoinPath(path).String(), nil)
+			if err != nil {
+				return
+			}
+			resp, err := client.Do(req)
+			if err == nil {
+				resp.Body.Close()
+				healthy = resp.StatusCode < http.StatusBadRequest
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			u.mu.Lock()
+			u.healthy = healthy
+			u.mu.Unlock()
+		}(u)
+	}
+	wg.Wait()
+}
+
+// checkHealthEvery checks the health of the upstreams at each interval, until
+// stop is closed.  done is closed once it returns.
+func (p *Proxy) checkHealthEvery(path string, interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.checkHealth(ctx, path, interval)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// stopHealthChecks stops the health checks, if any, and waits for them to
+// finish.  It must be called with healthMu held.
+func (p *Proxy) stopHealthChecks() {
+	if p.stopHealth == nil {
+		return
+	}
+	close(p.stopHealth)
+	<-p.healthDone
+	p.stopHealth, p.healthDone = nil, nil
+}
+
+// SetPassiveEjection sets the proxy to stop forwarding requests to an upstream
+// for the given duration once maxFails requests to it have failed in a row.
+// A request fails if the upstream can not be reached, or responds with 502,
+// 503, or 504.
+func (p *Proxy) SetPassiveEjection(maxFails int, d time.Duration) *Proxy {
+	p.maxFails, p.ejectFor = maxFails, d
+	return p
+}
+
+// PassiveEjection returns the number of failed requests in a row after which
+// an upstream is ejected, and for how long.
+func (p *Proxy) PassiveEjection() (int, time.Duration) {
+	return p.maxFails, p.ejectFor
+}
+
+// UnsetPassiveEjection stops ejecting upstreams due to failed requests.
+func (p *Proxy) UnsetPassiveEjection() {
+	p.maxFails, p.ejectFor = 0, 0
+}
+
+// Proxy sets the route to forward requests to the given upstream URLs, using
+// a Proxy with the default settings.  If an upstream URL is not valid, an
+// error is set on the route, and no handler is set.
+func (r *Route) Proxy(upstreams ...string) *Route {
+	p, err := NewProxy(upstreams...)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	return r.ProxyTo(p)
+}
+
+// ProxyTo sets the route to forward requests using p.
+func (r *Route) ProxyTo(p *Proxy) *Route {
+	r.handler = p.serve
+	return r
+}
+
+// serve forwards the request to an available upstream.
+func (p *Proxy) serve(w http.ResponseWriter, req *Request) {
+	available := make([]*Upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if u.Available() {
+			available = append(available, u)
+		}
+	}
+	if len(available) == 0 {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	u := p.balancer.Pick(req, available)
+
+	atomic.AddInt64(&u.active, 1)
+	defer atomic.AddInt64(&u.active, -1)
+	ctx := context.WithValue(req.Request.Context(), proxyKey{}, &proxied{req: req, upstream: u})
+	p.proxy.ServeHTTP(w, req.Request.WithContext(ctx))
+}
+
+// rewriteRequest prepares the outgoing request for the picked upstream.
+func (p *Proxy) rewriteRequest(pr *httputil.ProxyRequest) {
+	call := pr.In.Context().Value(proxyKey{}).(*proxied)
+	switch {
+	case p.rewrite != "":
+		pr.Out.URL.Path = expandRedirect(p.rewrite, call.req.Params, nil)
+		pr.Out.URL.RawPath = ""
+	case p.strip != "":
+		if rest := strings.TrimPrefix(pr.Out.URL.Path, p.strip); rest != pr.Out.URL.Path && (rest == "" || rest[0] == '/') {
+			if rest == "" {
+				rest = "/"
+			}
+			pr.Out.URL.Path = rest
+			pr.Out.URL.RawPath = ""
+		}
+	}
+	pr.SetURL(call.upstream.url)
+
+	// Extend the chain of proxies if the request came through a trusted one.
+	if _, ok := pr.In.Context().Value(forwardedKey{}).(*forwardedInfo); ok {
+		if prior := pr.In.Header["X-Forwarded-For"]; len(prior) > 0 {
+			pr.Out.Header["X-Forwarded-For"] = append([]string(nil), prior...)
+		}
+	}
+	pr.SetXForwarded()
+	pr.Out.Header.Set("X-Forwarded-Host", call.req.Host())
+	pr.Out.Header.Set("X-Forwarded-Proto", call.req.Scheme())
+}
+
+// modifyResponse records the outcome of the request to the upstream.
+func (p *Proxy) modifyResponse(resp *http.Response) error {
+	call := resp.Request.Context().Value(proxyKey{}).(*proxied)
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		p.recordFailure(call.upstream)
+	default:
+		call.upstream.mu.Lock()
+		call.upstream.fails = 0
+		call.upstream.mu.Unlock()
+	}
+	return nil
+}
+
+// handleError responds to a request that could not be forwarded.
+func (p *Proxy) handleError(w http.ResponseWriter, req *http.Request, err error) {
+	// Requests canceled by the client are not the upstream's fault.
+	if call, ok := req.Context().Value(proxyKey{}).(*proxied); ok && !errors.Is(err, context.Canceled) {
+		p.recordFailure(call.upstream)
+	}
+	http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+}
+
+// recordFailure counts a failed request to u, and ejects u if it has failed
+// too many times in a row.
+func (p *Proxy) recordFailure(u *Upstream) {
+	if p.maxFails <= 0 {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.fails++; u.fails >= p.maxFails {
+		u.ejectedUntil = time.Now().Add(p.ejectFor)
+		u.fails = 0
+	}
+}
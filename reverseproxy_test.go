@@ -0,0 +1,212 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newUpstream starts a test server that responds with its name, the path and
+// query it received, and the X-Forwarded-* headers.
+func newUpstream(t *testing.T, name string) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "%s %s %s %s %s", name, req.URL.RequestURI(), req.Header.Get("X-Forwarded-For"), req.Header.Get("X-Forwarded-Host"), req.Header.Get("X-Forwarded-Proto"))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// proxyGet makes a request through the router, returning the status code and
+// body of the response.
+func proxyGet(router *Router, target string, header http.Header) (int, string) {
+	req := httptest.NewRequest("GET", target, nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	body, _ := io.ReadAll(w.Body)
+	return w.Code, string(body)
+}
+
+func TestRouteProxy(t *testing.T) {
+	a, b := newUpstream(t, "a"), newUpstream(t, "b")
+	router := NewRouter().SetTrustedProxies("192.0.2.1")
+	router.NewRoute().SetPrefix("/rr/").Proxy(a.URL, b.URL)
+	strip, _ := NewProxy(a.URL)
+	router.NewRoute().SetPrefix("/api/").ProxyTo(strip.SetStripPrefix("/api/"))
+	rewrite, _ := NewProxy(b.URL + "/base")
+	router.NewRoute().SetPath("/users/{id:[0-9]+}").ProxyTo(rewrite.SetRewrite("/v2/accounts/{id}"))
+
+	type proxyTest struct {
+		path   string
+		header http.Header
+		body   string
+	}
+
+	tests := []proxyTest{
+		{"/rr/x?q=1", nil, "a /rr/x?q=1 192.0.2.1 example.com http"},                          // 0
+		{"/rr/x?q=1", nil, "b /rr/x?q=1 192.0.2.1 example.com http"},                          // 1
+		{"/api/items/3", nil, "a /items/3 192.0.2.1 example.com http"},                        // 2
+		{"/api/", nil, "a / 192.0.2.1 example.com http"},                                      // 3
+		{"/users/42?full=1", nil, "b /base/v2/accounts/42?full=1 192.0.2.1 example.com http"}, // 4
+		{ // 5
+			// Details from trusted proxies are passed on.
+			"/api/items",
+			http.Header{"X-Forwarded-For": {"203.0.113.9"}, "X-Forwarded-Host": {"www.example.org"}, "X-Forwarded-Proto": {"https"}},
+			"a /items 203.0.113.9, 192.0.2.1 www.example.org https",
+		},
+	}
+
+	for i, test := range tests {
+		code, body := proxyGet(router, "http://example.com"+test.path, test.header)
+		if code != http.StatusOK || body != test.body {
+			t.Errorf("tests[%v]: Expected '200 %v', received '%v %v'.", i, test.body, code, body)
+		}
+	}
+
+	for _, upstreams := range [][]string{nil, {"ftp://example.com"}, {"http://"}} {
+		if route := router.NewRoute().Proxy(upstreams...); route.Error() == nil {
+			t.Errorf("Expected an error for upstreams '%v', received none.", upstreams)
+		}
+	}
+}
+
+func TestProxyBalancers(t *testing.T) {
+	upstreams := []*Upstream{}
+	for _, name := range []string{"http://a", "http://b", "http://c"} {
+		p, _ := NewProxy(name)
+		upstreams = append(upstreams, p.Upstreams()[0])
+	}
+
+	// Least connections prefers the least busy upstream.
+	upstreams[0].active, upstreams[1].active, upstreams[2].active = 3, 1, 2
+	if u := LeastConnections().Pick(&Request{}, upstreams); u != upstreams[1] {
+		t.Errorf("Expected upstream '%v', received '%v'.", upstreams[1].URL(), u.URL())
+	}
+
+	// Consistent hashing is stable, and only moves keys of a removed upstream.
+	hash := ConsistentHash("user")
+	picks := make(map[string]*Upstream)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprint(i)
+		req := &Request{Params: map[string]string{"user": key}}
+		picks[key] = hash.Pick(req, upstreams)
+		if hash.Pick(req, upstreams) != picks[key] {
+			t.Errorf("Expected the same upstream for key '%v'.", key)
+		}
+	}
+	for key, u := range picks {
+		req := &Request{Params: map[string]string{"user": key}}
+		picked := hash.Pick(req, upstreams[:2])
+		if u != upstreams[2] && picked != u {
+			t.Errorf("Expected key '%v' to stay on '%v', received '%v'.", key, u.URL(), picked.URL())
+		}
+	}
+}
+
+func TestProxyHealth(t *testing.T) {
+	healthy := true
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/healthz" && !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "a")
+	}))
+	defer a.Close()
+	b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer b.Close()
+
+	p, _ := NewProxy(a.URL, b.URL)
+	p.SetHealthCheck("/healthz", time.Hour).SetPassiveEjection(2, time.Hour)
+	defer p.UnsetHealthCheck()
+	router := NewRouter()
+	router.NewRoute().ProxyTo(p)
+
+	// Upstream b fails twice in a row, and is ejected.
+	for i := 0; i < 4; i++ {
+		proxyGet(router, "http://example.com/", nil)
+	}
+	if p.Upstreams()[1].Available() {
+		t.Error("Expected upstream b to be ejected.")
+	}
+	for i := 0; i < 3; i++ {
+		if code, body := proxyGet(router, "http://example.com/", nil); code != http.StatusOK || body != "a" {
+			t.Errorf("%v: Expected '200 a', received '%v %v'.", i, code, body)
+		}
+	}
+
+	// Upstream a fails its health check, leaving nothing available.
+	healthy = false
+	p.CheckHealth()
+	if code, _ := proxyGet(router, "http://example.com/", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status '503', received '%v'.", code)
+	}
+	healthy = true
+	p.CheckHealth()
+	if code, _ := proxyGet(router, "http://example.com/", nil); code != http.StatusOK {
+		t.Errorf("Expected status '200', received '%v'.", code)
+	}
+
+	// Unreachable upstreams respond with 502.
+	c := httptest.NewServer(http.NotFoundHandler())
+	c.Close()
+	router = NewRouter()
+	router.NewRoute().SetPath("/down").Proxy(c.URL)
+	if code, _ := proxyGet(router, "http://example.com/down", nil); code != http.StatusBadGateway {
+		t.Errorf("Expected status '502', received '%v'.", code)
+	}
+}
+
+func TestProxyUnsetHealthCheck(t *testing.T) {
+	checking := make(chan struct{}, 1)
+	release := make(chan struct{})
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case checking <- struct{}{}:
+		default:
+		}
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer a.Close()
+	defer close(release)
+
+	// A check in progress when the health checks are unset does not mark the
+	// upstream unhealthy.
+	p, _ := NewProxy(a.URL)
+	p.SetHealthCheck("/healthz", 10*time.Millisecond)
+	<-checking
+	p.UnsetHealthCheck()
+	if path, interval := p.HealthCheck(); path != "" || interval != 0 {
+		t.Errorf("Expected no health check, received '%v' every '%v'.", path, interval)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !p.Upstreams()[0].Available() {
+		t.Error("Expected the upstream to be available.")
+	}
+
+	// Close stops the health checks, and can be called more than once.
+	p.SetHealthCheck("/healthz", 10*time.Millisecond)
+	<-checking
+	if err := p.Close(); err != nil {
+		t.Errorf("Expected no error, received '%v'.", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Expected no error, received '%v'.", err)
+	}
+}
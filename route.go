@@ -7,32 +7,55 @@ package routing
 import (
 	"fmt"
 	"net/http"
-	"regexp"
+	"net/url"
 	"strings"
+	"time"
 )
 
 // A HandlerFunc is the function signature of the handler that is called when
 // a route matches a request.
 type HandlerFunc func(http.ResponseWriter, *Request)
 
+// A Middleware wraps a HandlerFunc, returning a HandlerFunc that will be
+// called in its place.
+type Middleware func(HandlerFunc) HandlerFunc
+
 // A Route holds all the information about a route.
 type Route struct {
-	router       *Router
-	schemes      map[string]bool
-	host         *hostInfo
-	methods      map[string]bool
-	parentPath   string
-	path         *pathInfo
-	headers      http.Header
-	matchSlashes bool
-	handler      HandlerFunc
-	children     []*Route
-	err          error
+	router          *Router
+	schemes         map[string]bool
+	host            *hostInfo
+	methods         map[string]bool
+	parentPath      string
+	path            *pathInfo
+	headers         http.Header
+	websocket       bool
+	version         *versionRange
+	matchSlashes    bool
+	timeout         time.Duration
+	rateLimit       *rateLimit
+	cors            *CORSPolicy
+	auth            []Authenticator
+	policies        []*Policy
+	namePrefix      string // Prepended to names, set by a Group
+	handler         HandlerFunc
+	middleware      []Middleware
+	notFoundHandler http.HandlerFunc
+	children        []*Route
+	err             error
+}
+
+// Router returns the router that the route belongs to.
+func (r *Route) Router() *Router {
+	return r.router
 }
 
 // SetName sets a name for the route.  Route names must be unique across the
-// router.  If the name is already in use, an error is set on the route.
+// router.  If the name is already in use, an error is set on the route.  If
+// the route was created by a Group with a name prefix, the prefix is
+// prepended to n.
 func (r *Route) SetName(n string) *Route {
+	n = r.namePrefix + n
 	for _, v := range r.router.namedRoutes {
 		if n == v {
 			r.err = fmt.Errorf(errRouteAlreadyDefined, n)
@@ -55,9 +78,10 @@ func (r *Route) UnsetName() {
 }
 
 // SetSchemes sets a list of schemes that the route will match.  At least one
-// of the provided schemes must match for the route to match a request.  If an
-// unsupported scheme is provided, no schemes are set, and an error message
-// is set on the route.
+// of the provided schemes must match for the route to match a request.  The
+// "ws" and "wss" schemes only match WebSocket handshakes made over "http" and
+// "https" respectively.  If an unsupported scheme is provided, no schemes are
+// set, and an error message is set on the route.
 func (r *Route) SetSchemes(s ...string) *Route {
 	schemes, err := validateSchemes(s...)
 	if err != nil {
@@ -82,7 +106,21 @@ func (r *Route) UnsetSchemes() {
 	r.schemes = nil
 }
 
-// SetHost sets the host name that the route will match.
+// SetHost sets the host name that the route will match.  Parameters are
+// enclosed in braces, and contain either a regular expression, or a name and
+// a regular expression separated by a colon, such as "{sub:[a-z]+}".  Named
+// parameters are made available to the handler alongside path parameters.
+//
+// The host can be followed by a port, such as "api.local:8080" or
+// "api.local:{port:[0-9]+}", in which case requests that do not include a
+// port are matched against the default port of their scheme.  Without a port,
+// requests for any port will match.  IPv6 addresses must be enclosed in
+// brackets when followed by a port.  Host names are not case sensitive, and
+// trailing dots are ignored.
+//
+// Internationalized domain names can be given in either their Unicode or
+// punycode form, and will match requests using either form.  Labels that
+// contain non-ASCII characters must not be split by a parameter.
 func (r *Route) SetHost(h string) *Route {
 	host, err := parseHost(h)
 	if err != nil {
@@ -107,11 +145,12 @@ func (r *Route) UnsetHost() {
 }
 
 // SetMethods sets a list of methods that the route will match.  At least one
-// of the provided methods must match for the route to match a request.  If an
-// unsupported method is provided, no methods are set, and an error message
-// is set on the route.
+// of the provided methods must match for the route to match a request.  If a
+// method that has not been registered with the router is provided, no methods
+// are set, and an error message is set on the route.  See
+// Router.RegisterMethod.
 func (r *Route) SetMethods(m ...string) *Route {
-	methods, err := validateMethods(m...)
+	methods, err := validateMethods(r.router.methods, m...)
 	if err != nil {
 		r.err = err
 		return r
@@ -134,8 +173,11 @@ func (r *Route) UnsetMethods() {
 	r.methods = nil
 }
 
-// SetPath sets the path that the route will match.  If parsing of the path
-// fails, no path is set, and an error message is set on the route.
+// SetPath sets the path that the route will match.  Parameters are written as
+// "{name}" or "{name:pattern}", and a parameter with a pattern of "*", such as
+// "{path:*}", is a catch-all that matches the rest of the path, including any
+// slashes, and must come last.  If parsing of the path fails, no path is set,
+// and an error message is set on the route.
 func (r *Route) SetPath(p string) *Route {
 	return r.setPath(p, false)
 }
@@ -149,13 +191,7 @@ func (r *Route) SetPrefix(p string) *Route {
 // setPath does basic sanity checking of the path, and if the path appears to
 // be valid and parses correctly, sets the path of the route.
 func (r *Route) setPath(p string, matchPrefix bool) *Route {
-	if r.parentPath != "" {
-		if strings.HasSuffix(r.parentPath, "/") && strings.HasPrefix(p, "/") {
-			p = p[1:]
-		}
-		p = r.parentPath + p
-	}
-	parsedPath, err := parsePath(p, matchPrefix, r.matchSlashes)
+	parsedPath, err := r.parsePath(p, matchPrefix)
 	if err != nil {
 		r.err = err
 		return r
@@ -164,6 +200,18 @@ func (r *Route) setPath(p string, matchPrefix bool) *Route {
 	return r
 }
 
+// parsePath joins the path to the parent's path, if any, and parses the
+// result using the route's slash handling.
+func (r *Route) parsePath(p string, matchPrefix bool) (*pathInfo, error) {
+	if r.parentPath != "" {
+		if strings.HasSuffix(r.parentPath, "/") && strings.HasPrefix(p, "/") {
+			p = p[1:]
+		}
+		p = r.parentPath + p
+	}
+	return parsePath(p, matchPrefix, r.matchSlashes)
+}
+
 // Path returns the path that the route will match.
 func (r *Route) Path() string {
 	if r.path == nil {
@@ -241,14 +289,141 @@ func (r *Route) UnsetHandler() {
 	r.handler = nil
 }
 
-// Subroute creates a child Route.
+// SetNotFound sets the handler to be used when the route matches a request,
+// but none of its children do, and the route has no handler of its own.
+func (r *Route) SetNotFound(f http.HandlerFunc) *Route {
+	r.notFoundHandler = f
+	return r
+}
+
+// NotFound returns the handler used when the route matches a request, but
+// none of its children do.
+func (r *Route) NotFound() http.HandlerFunc {
+	return r.notFoundHandler
+}
+
+// UnsetNotFound clears the handler used when the route matches a request,
+// but none of its children do.  The router's handler will be used instead.
+func (r *Route) UnsetNotFound() {
+	r.notFoundHandler = nil
+}
+
+// SetMiddleware sets the list of middleware that the route's handler is
+// wrapped in.  The first middleware provided is the outermost, and is called
+// first.  In a Subroute tree, a route's middleware also wraps the handlers of
+// its matched children, so the middleware of every matched route runs, with
+// the parent's outside the child's.  Middleware set on a parent applies to
+// its children whether they were created before or after it was set.
+func (r *Route) SetMiddleware(m ...Middleware) *Route {
+	r.middleware = m
+	return r
+}
+
+// Middleware returns the list of middleware that the route's handler is
+// wrapped in.
+func (r *Route) Middleware() []Middleware {
+	return r.middleware
+}
+
+// UnsetMiddleware clears the list of middleware that the route's handler is
+// wrapped in.
+func (r *Route) UnsetMiddleware() {
+	r.middleware = nil
+}
+
+// Subroute creates a child Route.  The child inherits the schemes, host,
+// methods, headers, WebSocket matching, API version, trailing slash handling,
+// timeout, rate limit, CORS policy, required authenticators, access
+// policies, and not found handler of its parent, any of which can then be
+// changed without affecting the parent.  The parent's path is not inherited
+// directly, but is used as the base of any path set on the child.  The
+// parent's middleware is not copied, but wraps the child's handler along with
+// its own.  See Route.SetMiddleware.
+//
+// Child routes are only considered once their parent has matched a request.
+// The parent's handler is called first, and acts as middleware for its
+// children: calling Request.Next passes the request on to the matched child.
+// If none of the children match, the parent's handler is called on its own,
+// or if it has no handler, the not found handler is used.  See
+// Router.SetCascade for an alternative.
 func (r *Route) Subroute() *Route {
-	child := r.router.NewRoute()
+	child := r.inherit()
 	r.children = append(r.children, child)
-	child.parentPath = r.path.rawPath
 	return child
 }
 
+// inherit returns a new Route with the settings that a child of r inherits.
+// The new route is not added to the router.
+func (r *Route) inherit() *Route {
+	child := &Route{
+		router:          r.router,
+		schemes:         r.schemes,
+		host:            r.host,
+		methods:         r.methods,
+		websocket:       r.websocket,
+		version:         r.version,
+		matchSlashes:    r.matchSlashes,
+		timeout:         r.timeout,
+		rateLimit:       r.rateLimit,
+		cors:            r.cors,
+		auth:            r.auth,
+		policies:        r.policies,
+		namePrefix:      r.namePrefix,
+		notFoundHandler: r.notFoundHandler,
+	}
+	if r.path != nil {
+		child.parentPath = r.path.rawPath
+	}
+	if r.headers != nil {
+		child.headers = r.headers.Clone()
+	}
+	return child
+}
+
+// URL builds a URL for the route, filling in the path parameters with the
+// values found in params.  If the route's router is mounted on another
+// router, the mount point's path is prepended, and the mount point's host and
+// schemes are used when the route does not have a host of its own.  The host
+// is only included when it does not contain any patterns, in which case the
+// scheme is set to "https", "ws", or "wss" if that is the only scheme the
+// route matches, and "http" otherwise.  Internationalized host names are
+// converted to punycode.
+func (r *Route) URL(params map[string]string) (*url.URL, error) {
+	u := new(url.URL)
+	if r.path != nil {
+		p, err := r.path.build(params)
+		if err != nil {
+			return nil, err
+		}
+		u.Path = p
+	}
+	if r.host != nil && r.host.urlHost != "" {
+		u.Host = r.host.urlHost
+		u.Scheme = "http"
+		if len(r.schemes) == 1 {
+			for scheme := range r.schemes {
+				if scheme != "http" {
+					u.Scheme = scheme
+				}
+			}
+		}
+	}
+	if r.router.mount != nil {
+		base, err := r.router.mount.URL(params)
+		if err != nil {
+			return nil, err
+		}
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		u.Path = strings.TrimSuffix(base.Path, "/") + u.Path
+		if r.host == nil {
+			u.Host, u.Scheme = base.Host, base.Scheme
+		}
+	}
+	return u, nil
+}
+
 // Error returns the last route error that occurred.
 func (r *Route) Error() error {
 	return r.err
@@ -334,13 +509,14 @@ func (r *Route) XHR() *Route {
 
 // matchSchemes returns true if the route matches the request.
 func (r *Route) matchSchemes(req *http.Request) bool {
-	if len(r.schemes) > 0 {
-		isTLS := req.TLS != nil
-		if (isTLS && !r.schemes["https"]) || (!isTLS && !r.schemes["http"]) {
-			return false
-		}
+	if len(r.schemes) == 0 {
+		return true
 	}
-	return true
+	scheme := requestScheme(req)
+	if r.schemes[scheme] {
+		return true
+	}
+	return (isWebSocketHandshake(req) || isPreflightTarget(req)) && r.schemes[webSocketSchemes[scheme]]
 }
 
 // matchMethods returns true if the route matches the request.
@@ -354,7 +530,7 @@ func (r *Route) matchMethods(req *http.Request) bool {
 // matchHeaders returns true if the route matches the request.
 func (r *Route) matchHeaders(req *http.Request) bool {
 	matched := true
-	if len(r.headers) > 0 {
+	if len(r.headers) > 0 && !isPreflightTarget(req) {
 		for k, v := range r.headers {
 			if _, ok := req.Header[k]; !ok || !sliceContainsStrings(req.Header[k], v) {
 				matched = false
@@ -365,20 +541,29 @@ func (r *Route) matchHeaders(req *http.Request) bool {
 	return matched
 }
 
-// hostPortRegexp is used to strip the port number off of http.Request.Host.
-// FIXME: If the host is an IPv6 address, this will mangle it.
-var hostPortRegexp = regexp.MustCompile(":\\d{1,5}$")
+// matchWebSocket returns true if the route matches the request.
+func (r *Route) matchWebSocket(req *http.Request) bool {
+	return !r.websocket || isWebSocketHandshake(req) || isPreflightTarget(req)
+}
 
-// matchHost returns true if the route matches the request.
+// matchHost returns true if the route matches the request.  If the route's
+// host does not include a port, the request can be for any port.  Otherwise,
+// requests that do not include a port are assumed to be for the default port
+// of their scheme.
 func (r *Route) matchHost(req *http.Request) bool {
 	if r.host != nil {
-		host := req.Host
-		if hostPortRegexp.MatchString(host) {
-			host = host[:strings.LastIndex(host, ":")]
-		}
-		if !r.host.pattern.MatchString(host) {
+		name, port := splitRequestHost(requestHost(req))
+		if !r.host.pattern.MatchString(name) {
 			return false
 		}
+		if r.host.portPattern != nil {
+			if port == "" {
+				port = defaultPort(requestScheme(req))
+			}
+			if !r.host.portPattern.MatchString(port) {
+				return false
+			}
+		}
 	}
 	return true
 }
@@ -395,27 +580,48 @@ func (r *Route) matchPath(req *http.Request) bool {
 // Helpers
 //
 
+// wrap returns h wrapped in the route's middleware.
+func (r *Route) wrap(h HandlerFunc) HandlerFunc {
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		h = r.middleware[i](h)
+	}
+	return h
+}
+
+// getHostParams extracts the named host parameters from the request.
+func (r *Route) getHostParams(req *http.Request) map[string]string {
+	params := make(map[string]string)
+	if r.host == nil {
+		return params
+	}
+	name, port := splitRequestHost(requestHost(req))
+	addNamedSubmatches(params, r.host.pattern, name)
+	if r.host.portPattern != nil {
+		if port == "" {
+			port = defaultPort(requestScheme(req))
+		}
+		addNamedSubmatches(params, r.host.portPattern, port)
+	}
+	return params
+}
+
 // getPathParams extracts the path parameters from the provided path.
 func (r *Route) getPathParams(path string) (map[string]string, error) {
 	params := make(map[string]string)
-	if r.path.fwdPattern == nil {
+	if r.path == nil || r.path.fwdPattern == nil {
 		return params, nil
 	}
-	paramIndex := r.path.fwdPattern.FindStringSubmatchIndex(path)
-	if paramIndex == nil {
+	spans := r.path.paramSpans(path)
+	if spans == nil {
 		return params, nil
 	}
-
-	// paramIndex[i] is where the param starts, and paramIndex[i+1] is where it ends.
-	// Skip the first pair, since that is just [startOfString, endOfString].
-	for i, j := 2, 2; i < len(paramIndex); i += j - i {
-		for j += 2; j < len(paramIndex); j += 2 {
-			// Skip over all parameters that start and end between [i] and [i+1].
-			if paramIndex[i+1] <= paramIndex[j] {
-				break
-			}
+	for _, span := range spans {
+		if span[0] < 0 {
+			// Optional groups that did not participate in the match.
+			params[r.path.params[len(params)][0]] = ""
+		} else {
+			params[r.path.params[len(params)][0]] = path[span[0]:span[1]]
 		}
-		params[r.path.params[len(params)][0]] = path[paramIndex[i]:paramIndex[i+1]]
 	}
 	if len(params) != len(r.path.params) {
 		return nil, fmt.Errorf(errUnexpectedParamCount, len(r.path.params), len(params))
@@ -7,8 +7,10 @@ package routing
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // A Router holds all the defined routes, as well as defaults to be used for
@@ -16,10 +18,27 @@ import (
 type Router struct {
 	routes          []*Route
 	namedRoutes     map[*Route]string
+	methods         map[string]Method
 	notFoundHandler http.HandlerFunc
+	notAllowed      http.HandlerFunc
+	sendNotAllowed  bool            // Whether wrong methods get a 405 instead of a 404
 	schemes         map[string]bool // Default schemes applied to all routes
 	host            *hostInfo       // Default host name applied to all routes
 	matchSlashes    bool
+	cascade         bool
+	mount           *Route // Route this router is mounted on, if any
+	trustedProxies  []*net.IPNet
+	overrideMethods map[string]bool
+	overrideField   string
+	versioning      VersionStrategy
+	versionHeader   string
+	deprecations    []versionDeprecation
+	timeout         time.Duration // Default timeout applied to all routes
+	timeoutHandler  http.HandlerFunc
+	rateLimitStore  RateLimitStore
+	observers       []Observer
+	tracer          Tracer
+	cors            *CORSPolicy // Policy of routes without one of their own
 	err             error
 }
 
@@ -29,12 +48,29 @@ type Request struct {
 	Request *http.Request
 	Route   *Route
 	Params  map[string]string
+	next    func(http.ResponseWriter, *http.Request)
+}
+
+// Next passes the request on to the next matched route in a Subroute tree,
+// using w to write the response.  Changes made to r.Request are passed along
+// as well.  If there is no next route, Next does nothing.
+func (r *Request) Next(w http.ResponseWriter) {
+	if r.next != nil {
+		r.next(w, r.Request)
+	}
 }
 
 // NewRouter returns a new Router.
 func NewRouter() *Router {
 	router := &Router{
-		namedRoutes: make(map[*Route]string),
+		namedRoutes:    make(map[*Route]string),
+		methods:        make(map[string]Method),
+		overrideField:  "_method",
+		versionHeader:  "Api-Version",
+		rateLimitStore: NewMemoryRateLimitStore(),
+	}
+	for _, m := range defaultMethods {
+		router.methods[m.Name] = m
 	}
 	return router
 }
@@ -50,6 +86,30 @@ func (r *Router) NotFound() http.HandlerFunc {
 	return r.notFoundHandler
 }
 
+// SetMethodNotAllowed sets the handler to be used when no routes match a
+// request, but some would if not for the request's method.  The Allow header
+// is set to the methods that would match before the handler is called.  If f
+// is nil, a 405 Method Not Allowed response is sent.
+//
+// By default, such requests are handled the same as any other request that no
+// route matches, by the not found handler.
+func (r *Router) SetMethodNotAllowed(f http.HandlerFunc) *Router {
+	r.notAllowed, r.sendNotAllowed = f, true
+	return r
+}
+
+// MethodNotAllowed returns the handler used when no routes match a request,
+// but some would if not for the request's method.
+func (r *Router) MethodNotAllowed() http.HandlerFunc {
+	return r.notAllowed
+}
+
+// UnsetMethodNotAllowed clears the method not allowed handler, so that
+// requests with the wrong method are handled by the not found handler again.
+func (r *Router) UnsetMethodNotAllowed() {
+	r.notAllowed, r.sendNotAllowed = nil, false
+}
+
 // SetHost sets a host name that will be applied to all newly created routes.
 func (r *Router) SetHost(h string) *Router {
 	host, err := parseHost(h)
@@ -124,17 +184,39 @@ func (r *Router) MatchSlashes() bool {
 	return r.matchSlashes
 }
 
+// SetCascade sets how requests are dispatched to a Subroute tree.  By
+// default, a parent's handler is responsible for passing the request on to
+// its matched child by calling Request.Next.  If cascade is true, the handler
+// of every matched route is called in turn instead, starting with the
+// parent, and Request.Next does nothing.
+func (r *Router) SetCascade(b bool) *Router {
+	r.cascade = b
+	return r
+}
+
+// Cascade returns the status of cascade.
+func (r *Router) Cascade() bool {
+	return r.cascade
+}
+
 // NewRoute creates a new Route using defaults supplied by SetSchemes(),
-// SetHost(), and SetMatchSlashes().
+// SetHost(), SetMatchSlashes(), and SetTimeout().
 func (r *Router) NewRoute() *Route {
-	route := &Route{
+	route := r.defaultRoute()
+	r.routes = append(r.routes, route)
+	return route
+}
+
+// defaultRoute returns a new Route using the router's defaults.  The new
+// route is not added to the router.
+func (r *Router) defaultRoute() *Route {
+	return &Route{
 		router:       r,
 		schemes:      r.schemes,
 		host:         r.host,
 		matchSlashes: r.matchSlashes,
+		timeout:      r.timeout,
 	}
-	r.routes = append(r.routes, route)
-	return route
 }
 
 // Route returns the route named by n.  If no route with that name exists, an
@@ -161,61 +243,224 @@ func (r *Router) UnsetError() {
 // ServeHTTP accepts incoming requests and attempts to find a route that
 // matches it.
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	req = r.forwarded(req)
+
 	// Clean up the Request path.
-	// Borrowed from net/http/server.go
-	if req.Method != "CONNECT" {
-		// Clean path to canonical form and redirect.
-		if p := cleanPath(req.URL.Path); p != req.URL.Path {
-			w.Header().Set("Location", p)
-			w.WriteHeader(http.StatusMovedPermanently)
-			return
-		}
+	if location := cleanPathRedirect(req); location != "" {
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusMovedPermanently)
+		return
 	}
 
-	r.handleRequest(w, req, r.routes)
+	// Apply any method override and version before routes are matched.
+	r.handleRequest(w, r.versioned(r.overrideMethod(req, true)))
 }
 
 // handleRequest attempts to find a route that matches the current request,
 // then takes the proper steps to send the request to the route's handler.
-func (r *Router) handleRequest(w http.ResponseWriter, req *http.Request, routes []*Route) {
+func (r *Router) handleRequest(w http.ResponseWriter, req *http.Request) {
 	// See if there are any routes that match the request.
-	route := match(req, routes)
+	m, err := r.match(req)
+	if err != nil {
+		m = &RouteMatch{}
+	}
+	route, chain, params := m.Route, m.Chain, m.chainParams
+
+	// Preflight requests answered by the router are observed as requests to
+	// the route they ask about.
+	observed, preflight := route, false
+	if err == nil && isPreflight(req) {
+		if target, ok := r.preflightRoute(req); ok {
+			observed, preflight = target, true
+		}
+	}
+	for _, o := range r.observers {
+		var done func()
+		w, done = o.Observe(w, req, observed)
+		defer done()
+	}
+	if r.tracer != nil {
+		var end func()
+		w, req, end = r.startSpan(w, req, route, params)
+		defer end()
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if preflight {
+		r.servePreflight(w, req, observed)
+		return
+	}
 	if route == nil {
-		if r.notFoundHandler == nil {
-			http.NotFound(w, req)
+		if len(m.Allowed) > 0 && r.sendNotAllowed {
+			r.methodNotAllowed(w, req, m.Allowed)
 		} else {
-			r.notFoundHandler(w, req)
+			r.notFound(w, req)
 		}
 		return
 	}
+	req = r.resolveVersion(w, req, route, m.Params)
 
 	// Redirect to clean up trailing slashes if needed.
-	if route.path != nil && route.matchSlashes {
-		if strings.HasSuffix(route.path.rawPath, "/") && !strings.HasSuffix(req.URL.Path, "/") {
-			http.Redirect(w, req, req.URL.Path+"/", http.StatusMovedPermanently)
-			return
-		} else if !strings.HasSuffix(route.path.rawPath, "/") && strings.HasSuffix(req.URL.Path, "/") {
-			http.Redirect(w, req, req.URL.Path[:len(req.URL.Path)-1], http.StatusMovedPermanently)
-			return
+	if m.Redirect != "" {
+		http.Redirect(w, req, m.Redirect, m.RedirectCode)
+		return
+	}
+
+	r.applyCORS(w, req, route)
+
+	serve := HandlerFunc(func(w http.ResponseWriter, req *Request) {
+		if r.cascade {
+			r.serveAll(w, req.Request, chain, params, 0)
+		} else {
+			r.serveChain(w, req.Request, chain, params, 0)
 		}
+	})
+	if route.timeout > 0 {
+		serve = r.withTimeout(route.timeout, serve)
 	}
+	if policies := requiredPolicies(chain); len(policies) > 0 {
+		serve = r.withPolicies(policies, serve)
+	}
+	if auths := requiredAuth(chain); len(auths) > 0 {
+		serve = r.withAuth(auths, serve)
+	}
+	if route.rateLimit != nil {
+		serve = r.withRateLimit(route, serve)
+	}
+	serve(w, &Request{
+		Request: req,
+		Route:   route,
+		Params:  params[len(params)-1],
+	})
+}
 
-	// If the route has a handler defined, call it.
-	if route.handler != nil {
-		params, err := route.getPathParams(req.URL.Path)
+// cleanPathRedirect returns the location that req should be redirected to
+// in order to clean up its path, or an empty string if it needs no cleaning.
+// Borrowed from net/http/server.go
+func cleanPathRedirect(req *http.Request) string {
+	if req.Method != "CONNECT" {
+		if p := cleanPath(req.URL.Path); p != req.URL.Path {
+			return mountPrefix(req) + p
+		}
+	}
+	return ""
+}
+
+// chainParams returns the parameters seen by each route in chain.  Each route
+// sees its host and path parameters, as well as those of its parents.
+func chainParams(req *http.Request, chain []*Route) ([]map[string]string, error) {
+	params := make([]map[string]string, len(chain))
+	for i, route := range chain {
+		p, err := route.getPathParams(req.URL.Path)
 		if err != nil {
-			// FIXME: Is a panic the best way to handle an error here?
-			panic(err)
+			return nil, err
+		}
+		for k, v := range route.getHostParams(req) {
+			if _, exists := p[k]; !exists {
+				p[k] = v
+			}
+		}
+		if i > 0 {
+			for k, v := range params[i-1] {
+				if _, exists := p[k]; !exists {
+					p[k] = v
+				}
+			}
+		}
+		params[i] = p
+	}
+	return params, nil
+}
+
+// serveChain serves the request with the routes in chain[i:].  Each route's
+// middleware wraps its handler along with the rest of the chain.  The handler
+// of the first route that has one is called, and can pass the request on to
+// the rest of the chain by calling Request.Next.
+func (r *Router) serveChain(w http.ResponseWriter, req *http.Request, chain []*Route, params []map[string]string, i int) {
+	if i == len(chain) {
+		r.serveUnresolved(w, req, chain[len(chain)-1])
+		return
+	}
+	route := chain[i]
+	next := func(w http.ResponseWriter, req *http.Request) {
+		r.serveChain(w, req, chain, params, i+1)
+	}
+	route.wrap(func(w http.ResponseWriter, req *Request) {
+		if route.handler == nil {
+			next(w, req.Request)
+			return
 		}
-		route.handler(w, &Request{
-			Request: req,
-			Route:   route,
-			Params:  params,
-		})
+		req.next = next
+		route.handler(w, req)
+	})(w, &Request{
+		Request: req,
+		Route:   route,
+		Params:  params[i],
+	})
+}
+
+// serveAll calls the handler of every route in chain[i:], in order.  Each
+// route's middleware wraps its handler along with the rest of the chain.
+func (r *Router) serveAll(w http.ResponseWriter, req *http.Request, chain []*Route, params []map[string]string, i int) {
+	if i == len(chain) {
+		r.serveUnresolved(w, req, chain[len(chain)-1])
+		return
 	}
+	route := chain[i]
+	route.wrap(func(w http.ResponseWriter, req *Request) {
+		if route.handler != nil {
+			route.handler(w, req)
+		}
+		r.serveAll(w, req.Request, chain, params, i+1)
+	})(w, &Request{
+		Request: req,
+		Route:   route,
+		Params:  params[i],
+	})
+}
+
+// serveUnresolved calls the not found handler if route has children, none of
+// which matched the request, and no handler of its own.
+func (r *Router) serveUnresolved(w http.ResponseWriter, req *http.Request, route *Route) {
+	if len(route.children) == 0 || route.handler != nil {
+		return
+	}
+	if allowed := r.notAllowedMethods(req, route.children); len(allowed) > 0 {
+		r.methodNotAllowed(w, req, allowed)
+	} else if route.notFoundHandler != nil {
+		route.notFoundHandler(w, req)
+	} else {
+		r.notFound(w, req)
+	}
+}
+
+// notAllowedMethods returns the methods that would match req if any of routes
+// would match it if not for its method, and 405 responses are enabled.
+func (r *Router) notAllowedMethods(req *http.Request, routes []*Route) []string {
+	if !r.sendNotAllowed {
+		return nil
+	}
+	return allowedMethods(req, routes)
+}
+
+// methodNotAllowed sets the Allow header, then calls the router's method not
+// allowed handler.
+func (r *Router) methodNotAllowed(w http.ResponseWriter, req *http.Request, allowed []string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	if r.notAllowed == nil {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	} else {
+		r.notAllowed(w, req)
+	}
+}
 
-	// Handle any child routes.
-	if len(route.children) > 0 {
-		r.handleRequest(w, req, route.children)
+// notFound calls the router's not found handler.
+func (r *Router) notFound(w http.ResponseWriter, req *http.Request) {
+	if r.notFoundHandler == nil {
+		http.NotFound(w, req)
+	} else {
+		r.notFoundHandler(w, req)
 	}
 }
@@ -8,6 +8,8 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"testing"
 )
 
@@ -340,8 +342,116 @@ func TestRouterRoute(t *testing.T) {
 }
 
 func TestRouterHandleRequest(t *testing.T) {
-	// FIXME: I think this should probably be tested in some way, but I'm not
-	// entirely sure how to test it, or even what needs to be tested.
+	type handleRequestTest struct {
+		cascade bool
+		path    string
+		code    int
+		calls   []string
+	}
+
+	var calls []string
+	handler := func(name string, next bool) HandlerFunc {
+		return func(w http.ResponseWriter, r *Request) {
+			calls = append(calls, name+":"+r.Params["id"])
+			if next {
+				r.Next(w)
+			}
+		}
+	}
+	notFound := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			calls = append(calls, name)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+	router := NewRouter().SetNotFound(notFound("router"))
+	// A parent that acts as middleware for its children.
+	blog := router.NewRoute().SetPrefix("/blog/").SetHandler(handler("blog", true))
+	blog.Subroute().SetPath("/{id:[0-9]+}").SetHandler(handler("article", false))
+	// A parent that does not pass the request on.
+	router.NewRoute().SetPrefix("/private/").SetHandler(handler("private", false)).
+		Subroute().SetPath("/{id:[0-9]+}").SetHandler(handler("secret", false))
+	// A parent without a handler, and a subtree specific not found handler.
+	users := router.NewRoute().SetPrefix("/users/").SetNotFound(notFound("users"))
+	users.Subroute().SetPath("/{id:[0-9]+}").SetHandler(handler("user", false))
+	// A parent without a handler or not found handler.
+	router.NewRoute().SetPrefix("/static/").
+		Subroute().SetPath("/app.js").SetHandler(handler("static", false))
+
+	requests := []handleRequestTest{
+		{ // 0
+			path:  "/blog/1234",
+			code:  http.StatusOK,
+			calls: []string{"blog:", "article:1234"},
+		},
+		{ // 1
+			// With no matching child, the parent's handler is used alone.
+			path:  "/blog/abcd",
+			code:  http.StatusOK,
+			calls: []string{"blog:"},
+		},
+		{ // 2
+			path:  "/private/1234",
+			code:  http.StatusOK,
+			calls: []string{"private:"},
+		},
+		{ // 3
+			path:  "/users/1234",
+			code:  http.StatusOK,
+			calls: []string{"user:1234"},
+		},
+		{ // 4
+			path:  "/users/abcd",
+			code:  http.StatusNotFound,
+			calls: []string{"users"},
+		},
+		{ // 5
+			path:  "/static/app.css",
+			code:  http.StatusNotFound,
+			calls: []string{"router"},
+		},
+		{ // 6
+			path:  "/nonexistent",
+			code:  http.StatusNotFound,
+			calls: []string{"router"},
+		},
+		{ // 7
+			// Cascading calls every matched handler, regardless of Next.
+			cascade: true,
+			path:    "/private/1234",
+			code:    http.StatusOK,
+			calls:   []string{"private:", "secret:1234"},
+		},
+		{ // 8
+			cascade: true,
+			path:    "/blog/1234",
+			code:    http.StatusOK,
+			calls:   []string{"blog:", "article:1234"},
+		},
+		{ // 9
+			cascade: true,
+			path:    "/users/abcd",
+			code:    http.StatusNotFound,
+			calls:   []string{"users"},
+		},
+	}
+
+	for pos, r := range requests {
+		request, err := http.NewRequest("GET", r.path, nil)
+		if err != nil {
+			t.Fatalf("requests[%v]: Expected no error, received '%v'.", pos, err)
+		}
+		calls = nil
+		router.SetCascade(r.cascade)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != r.code {
+			t.Errorf("requests[%v]: Expected code %d, received %d.", pos, r.code, recorder.Code)
+		}
+		if !reflect.DeepEqual(calls, r.calls) {
+			t.Errorf("requests[%v]: Expected calls '%v', received '%v'.", pos, r.calls, calls)
+		}
+	}
 }
 
 //
@@ -640,6 +750,219 @@ func TestRouteSubroute(t *testing.T) {
 	if child.Path() != combinedPath {
 		t.Errorf("Expected path '%v', received '%v'.", combinedPath, child.Path())
 	}
+
+	// Subroutes are not matched directly by the router.
+	for _, route := range router.routes {
+		if route == child {
+			t.Error("Expected subroute to not be a top level route.")
+		}
+	}
+}
+
+func TestRouteSubroute_inherit(t *testing.T) {
+	var calls []string
+	middleware := func(h HandlerFunc) HandlerFunc {
+		return func(w http.ResponseWriter, r *Request) {
+			calls = append(calls, "middleware")
+			h(w, r)
+		}
+	}
+	router := NewRouter()
+	parent := router.NewRoute().
+		SetHost("admin.example.com").
+		SetSchemes("https").
+		SetMethods("GET", "POST").
+		SetHeader("X-Requested-With", "XMLHttpRequest").
+		SetMatchSlashes(true).
+		SetMiddleware(middleware)
+
+	// A parent without a path can still have children.
+	child := parent.Subroute()
+	if child.Host() != parent.Host() {
+		t.Errorf("Expected host '%v', received '%v'.", parent.Host(), child.Host())
+	}
+	if !slicesAreSimilar(child.Schemes(), parent.Schemes()) {
+		t.Errorf("Expected schemes '%v' to be similar to '%v'.", child.Schemes(), parent.Schemes())
+	}
+	if !slicesAreSimilar(child.Methods(), parent.Methods()) {
+		t.Errorf("Expected methods '%v' to be similar to '%v'.", child.Methods(), parent.Methods())
+	}
+	if child.Headers().Get("X-Requested-With") != "XMLHttpRequest" {
+		t.Errorf("Expected headers '%v' to be inherited, received '%v'.", parent.Headers(), child.Headers())
+	}
+	if !child.MatchSlashes() {
+		t.Error("Expected MatchSlashes to be true, received false.")
+	}
+	if len(child.Middleware()) != 0 {
+		t.Errorf("Expected the parent's middleware not to be copied, received %d.", len(child.Middleware()))
+	}
+	if child.Path() != "" || child.parentPath != "" {
+		t.Errorf("Expected empty path, received '%v'.", child.Path())
+	}
+
+	// Changing the child does not affect the parent.
+	child.SetHost("www.example.com").SetMethods("PUT").SetHeader("Dnt", "1")
+	child.UnsetMiddleware()
+	if parent.Host() != "admin.example.com" {
+		t.Errorf("Expected host 'admin.example.com', received '%v'.", parent.Host())
+	}
+	if !slicesAreSimilar(parent.Methods(), []string{"GET", "POST"}) {
+		t.Errorf("Expected methods '[GET POST]', received '%v'.", parent.Methods())
+	}
+	if _, exists := parent.Headers()["Dnt"]; exists {
+		t.Errorf("Expected header 'Dnt' to not exist in '%v'.", parent.Headers())
+	}
+	if len(parent.Middleware()) != 1 {
+		t.Errorf("Expected 1 middleware, received %d.", len(parent.Middleware()))
+	}
+
+	// An inherited host is enforced on the child.
+	router = NewRouter()
+	parent = router.NewRoute().SetHost("admin.example.com").SetMiddleware(middleware)
+	parent.Subroute().Get("/users").SetHandler(func(w http.ResponseWriter, r *Request) {
+		calls = append(calls, "handler")
+	})
+	request, err := http.NewRequest("GET", "http://www.example.com/users", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	calls = nil
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	if len(calls) != 0 {
+		t.Errorf("Expected no calls, received '%v'.", calls)
+	}
+	request.Host = "admin.example.com"
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	if !reflect.DeepEqual(calls, []string{"middleware", "handler"}) {
+		t.Errorf("Expected calls '[middleware handler]', received '%v'.", calls)
+	}
+}
+
+func TestRouteMiddleware(t *testing.T) {
+	var calls []string
+	middleware := func(name string) Middleware {
+		return func(h HandlerFunc) HandlerFunc {
+			return func(w http.ResponseWriter, r *Request) {
+				calls = append(calls, name)
+				h(w, r)
+			}
+		}
+	}
+	router := NewRouter()
+	route := router.NewRoute()
+
+	// The default state is empty.
+	if len(route.Middleware()) != 0 {
+		t.Errorf("Expected no middleware, received %d.", len(route.Middleware()))
+	}
+
+	// Middleware is called in the order it was provided.
+	route.SetMiddleware(middleware("first"), middleware("second")).
+		SetHandler(func(w http.ResponseWriter, r *Request) {
+			calls = append(calls, "handler")
+		})
+	request, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	if !reflect.DeepEqual(calls, []string{"first", "second", "handler"}) {
+		t.Errorf("Expected calls '[first second handler]', received '%v'.", calls)
+	}
+
+	// Middleware can be unset.
+	route.UnsetMiddleware()
+	if len(route.Middleware()) != 0 {
+		t.Errorf("Expected no middleware, received %d.", len(route.Middleware()))
+	}
+
+	// A parent's middleware wraps the handlers of its children, even if it is
+	// set after they are created, and a child's middleware does not replace
+	// it.
+	router = NewRouter()
+	parent := router.NewRoute().SetPrefix("/admin/")
+	parent.Subroute().SetPath("/users").SetMiddleware(middleware("users")).
+		SetHandler(func(w http.ResponseWriter, r *Request) {
+			calls = append(calls, "handler")
+		})
+	parent.SetMiddleware(middleware("admin")).SetHandler(func(w http.ResponseWriter, r *Request) {
+		calls = append(calls, "parent")
+		r.Next(w)
+	})
+
+	type middlewareTest struct {
+		cascade bool
+		calls   []string
+	}
+
+	tests := []middlewareTest{
+		{false, []string{"admin", "parent", "users", "handler"}}, // 0
+		{true, []string{"admin", "parent", "users", "handler"}},  // 1
+	}
+
+	for i, test := range tests {
+		calls = nil
+		router.SetCascade(test.cascade)
+		request, _ = http.NewRequest("GET", "/admin/users", nil)
+		router.ServeHTTP(httptest.NewRecorder(), request)
+		if !reflect.DeepEqual(calls, test.calls) {
+			t.Errorf("requests[%v]: Expected calls '%v', received '%v'.", i, test.calls, calls)
+		}
+	}
+}
+
+func TestRouteURL(t *testing.T) {
+	router := NewRouter()
+	route := router.NewRoute().SetPath("/blog/{id:[0-9]+}/{slug:[-a-z]+}/")
+
+	// All parameters are filled in.
+	u, err := route.URL(map[string]string{"id": "1234", "slug": "super-cool-article"})
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	if u.String() != "/blog/1234/super-cool-article/" {
+		t.Errorf("Expected URL '/blog/1234/super-cool-article/', received '%v'.", u)
+	}
+
+	// Missing parameters are an error.
+	if _, err = route.URL(map[string]string{"id": "1234"}); err == nil {
+		t.Error("Expected an error, received none.")
+	}
+
+	// Parameter values must match their pattern.
+	if _, err = route.URL(map[string]string{"id": "abcd", "slug": "article"}); err == nil {
+		t.Error("Expected an error, received none.")
+	}
+
+	// Literal hosts are included, along with the scheme.
+	route.SetHost("example.com").SetSchemes("https")
+	u, err = route.URL(map[string]string{"id": "1", "slug": "a"})
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	if u.String() != "https://example.com/blog/1/a/" {
+		t.Errorf("Expected URL 'https://example.com/blog/1/a/', received '%v'.", u)
+	}
+
+	// Hosts containing patterns are not.
+	route.SetHost("{[a-z]+}.example.com")
+	u, err = route.URL(map[string]string{"id": "1", "slug": "a"})
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	if u.String() != "/blog/1/a/" {
+		t.Errorf("Expected URL '/blog/1/a/', received '%v'.", u)
+	}
+
+	// Literal percent signs survive.
+	route.SetPath("/100%/{id:[0-9]+}")
+	u, err = route.URL(map[string]string{"id": "1"})
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	if u.Path != "/100%/1" {
+		t.Errorf("Expected path '/100%%/1', received '%v'.", u.Path)
+	}
 }
 
 //
@@ -779,8 +1102,8 @@ func TestRouteMatchHeaders(t *testing.T) {
 
 func TestRouteMatchHost_invalid(t *testing.T) {
 	hosts := []string{
-		// The port number is stripped off the request before matching.
-		"www.example.com:8080",
+		// A different port doesn't match.
+		"www.example.com:80",
 		// A partial match isn't good enough.
 		"example.com",
 	}
@@ -795,7 +1118,7 @@ func TestRouteMatchHost_invalid(t *testing.T) {
 	for _, h := range hosts {
 		route.SetHost(h)
 		if route.matchHost(request) {
-			t.Error("Expected host '%v' to not match the request.", h)
+			t.Errorf("Expected host '%v' to not match the request.", h)
 		}
 	}
 }
@@ -804,8 +1127,10 @@ func TestRouteMatchHost_valid(t *testing.T) {
 	hosts := []string{
 		"",
 		"www.example.com",
+		"www.example.com:8080",
 		"{[a-z]+}.example.com",
 		"www.example.{[a-z]{2,4}}",
+		"www.example.com:{port:[0-9]+}",
 	}
 	request, err := http.NewRequest("GET", "/", nil)
 	if err != nil {
@@ -816,9 +1141,89 @@ func TestRouteMatchHost_valid(t *testing.T) {
 	route := router.NewRoute()
 
 	for _, h := range hosts {
-		route.SetHost(h)
+		route.UnsetHost()
+		if h != "" {
+			route.SetHost(h)
+		}
 		if !route.matchHost(request) {
-			t.Error("Expected host '%v' to match the request.", h)
+			t.Errorf("Expected host '%v' to match the request.", h)
+		}
+	}
+}
+
+func TestRouteMatchHost_matrix(t *testing.T) {
+	type matchHostTest struct {
+		routeHost   string
+		requestHost string
+		isTLS       bool
+		matched     bool
+		params      map[string]string
+	}
+
+	tests := []matchHostTest{
+		// Ports are ignored unless the route specifies one.
+		{"example.com", "example.com", false, true, nil},
+		{"example.com", "example.com:8080", false, true, nil},
+		{"example.com:8080", "example.com:8080", false, true, nil},
+		{"example.com:8080", "example.com:8081", false, false, nil},
+		{"example.com:8080", "example.com", false, false, nil},
+		// Requests without a port use the default port of their scheme.
+		{"example.com:80", "example.com", false, true, nil},
+		{"example.com:443", "example.com", true, true, nil},
+		{"example.com:443", "example.com", false, false, nil},
+		{"example.com:{port:[0-9]+}", "example.com", true, true, map[string]string{"port": "443"}},
+		{"example.com:{port:80|8080}", "example.com:8080", false, true, map[string]string{"port": "8080"}},
+		{"example.com:{port:80|8080}", "example.com:8081", false, false, nil},
+		// Host names are not case sensitive, and trailing dots are ignored.
+		{"Example.COM", "example.com", false, true, nil},
+		{"example.com", "EXAMPLE.com:8080", false, true, nil},
+		{"example.com.", "example.com", false, true, nil},
+		{"example.com", "example.com.", false, true, nil},
+		{"{sub:[a-z]+}.example.com", "WWW.Example.com.:8080", false, true, map[string]string{"sub": "www"}},
+		// IPv4 addresses.
+		{"127.0.0.1", "127.0.0.1:8080", false, true, nil},
+		{"127.0.0.1:8080", "127.0.0.1:8080", false, true, nil},
+		{"127.0.0.1:8080", "127.0.0.2:8080", false, false, nil},
+		// IPv6 addresses, with or without brackets.
+		{"[::1]", "[::1]:8080", false, true, nil},
+		{"[::1]", "[::1]", false, true, nil},
+		{"::1", "[::1]:8080", false, true, nil},
+		{"[::1]:8080", "[::1]:8080", false, true, nil},
+		{"[::1]:8080", "[::1]:8081", false, false, nil},
+		{"[::1]:8080", "[::2]:8080", false, false, nil},
+		{"[0:0::1]", "[::1]", false, true, nil},
+		{"[2001:DB8::1]", "[2001:db8:0::1]:443", true, true, nil},
+		{"[::1]:{port:[0-9]+}", "[::1]:8080", false, true, map[string]string{"port": "8080"}},
+		// The port of an IPv6 address is not mistaken for part of the address.
+		{"[::1]", "[::1:8080]", false, false, nil},
+	}
+	router := NewRouter()
+	route := router.NewRoute()
+
+	for pos, v := range tests {
+		route.UnsetError()
+		route.SetHost(v.routeHost)
+		if route.Error() != nil {
+			t.Errorf("tests[%v]: Expected no error, received '%v'.", pos, route.Error())
+			continue
+		}
+		request, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatalf("tests[%v]: Expected no error, received '%v'.", pos, err)
+		}
+		request.Host = v.requestHost
+		if v.isTLS {
+			request.TLS = new(tls.ConnectionState)
+		}
+		if matched := route.matchHost(request); matched != v.matched {
+			t.Errorf("tests[%v]: Expected host '%v' matching '%v' to be %v, received %v.", pos, v.routeHost, v.requestHost, v.matched, matched)
+			continue
+		}
+		if v.params == nil {
+			continue
+		}
+		if params := route.getHostParams(request); !reflect.DeepEqual(params, v.params) {
+			t.Errorf("tests[%v]: Expected params '%v', received '%v'.", pos, v.params, params)
 		}
 	}
 }
@@ -1138,6 +1543,14 @@ func TestHelper_parseHost_invalid(t *testing.T) {
 	hosts := []string{
 		// Empty hosts are not valid.
 		"",
+		// Unterminated IPv6 address.
+		"[::1",
+		// Garbage after an IPv6 address.
+		"[::1]8080",
+		// Empty port.
+		"example.com:",
+		// Invalid port pattern.
+		"example.com:{([0-9]+}",
 		// More opening braces than closing braces.
 		"{{[a-z]+}.example.com",
 		// More closing braces than opening braces.
@@ -1167,6 +1580,24 @@ func TestHelper_parseHost_valid(t *testing.T) {
 			"{([a-z]+)([0-9]+)}.example.{[a-z]{2,4}}",
 			"^(([a-z]+)([0-9]+))\\.example\\.([a-z]{2,4})$",
 		},
+		{
+			"{sub:[a-z]+}.Example.COM.",
+			"^(?P<sub>[a-z]+)\\.example\\.com$",
+		},
+		{
+			"{sub:}.example.com:8080",
+			"^(?P<sub>[^.]+)\\.example\\.com$",
+			"^8080$",
+		},
+		{
+			"[2001:DB8::1]:{port:}",
+			"^2001:db8::1$",
+			"^(?P<port>[0-9]+)$",
+		},
+		{
+			"::1",
+			"^::1$",
+		},
 	}
 	var parsedHost *hostInfo
 	var err error
@@ -1182,6 +1613,15 @@ func TestHelper_parseHost_valid(t *testing.T) {
 		if parsedHost.pattern.String() != h[1] {
 			t.Errorf("Expected pattern '%v', received '%v'.", h[1], parsedHost.pattern.String())
 		}
+		if len(h) < 3 {
+			if parsedHost.portPattern != nil {
+				t.Errorf("Expected no port pattern, received '%v'.", parsedHost.portPattern.String())
+			}
+		} else if parsedHost.portPattern == nil {
+			t.Errorf("Expected port pattern '%v', received none.", h[2])
+		} else if parsedHost.portPattern.String() != h[2] {
+			t.Errorf("Expected port pattern '%v', received '%v'.", h[2], parsedHost.portPattern.String())
+		}
 	}
 }
 
@@ -0,0 +1,175 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package routingtest provides utilities for testing routers and handlers.
+//
+// Route tables can be checked without calling any handlers:
+//
+//	routingtest.Run(t, router, []routingtest.Case{
+//		{Method: "GET", URL: "/users/42", Route: "user", Params: map[string]string{"id": "42"}},
+//		{Method: "GET", URL: "/missing", Err: routing.ErrNotFound},
+//	})
+//
+// Handlers can be called directly with a request that carries params:
+//
+//	w := routingtest.Serve(handler, routingtest.NewRequest("GET", "/users/42", map[string]string{"id": "42"}))
+package routingtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	routing "github.com/timewasted/go-routing"
+)
+
+// A Case describes how a request is expected to be resolved by a router.
+type Case struct {
+	// Method is the request's method.  The default is "GET".
+	Method string
+	// URL is the request's URL.  If it has no host, "example.com" is used.
+	URL string
+	// Header holds any headers to add to the request.
+	Header http.Header
+	// Route is the name of the expected route, or its path if it has no
+	// name.
+	Route string
+	// Params holds the expected params.  If nil, params are not checked.
+	Params map[string]string
+	// Redirect is the expected redirect location, if any.
+	Redirect string
+	// Err is the expected error, such as routing.ErrNotFound.
+	Err error
+}
+
+// NewRequest returns a routing.Request for calling a handler directly, with
+// its params set to params.  The request is built by httptest.NewRequest, and
+// has no route.
+func NewRequest(method, target string, params map[string]string) *routing.Request {
+	if params == nil {
+		params = make(map[string]string)
+	}
+	return &routing.Request{
+		Request: httptest.NewRequest(method, target, nil),
+		Params:  params,
+	}
+}
+
+// Serve calls h with req, and returns the recorded response.
+func Serve(h routing.HandlerFunc, req *routing.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, req)
+	return w
+}
+
+// AssertRoute checks that req is resolved by router to the route named name,
+// or with the path name if it has no name, with the given params.  If params
+// is nil, params are not checked.  Failures are reported with t.Errorf.
+func AssertRoute(t testing.TB, router *routing.Router, req *http.Request, name string, params map[string]string) {
+	t.Helper()
+	if diff := check(router, req, Case{Route: name, Params: params}); diff != "" {
+		t.Errorf("%s %s:\n%s", req.Method, req.URL, diff)
+	}
+}
+
+// Run checks each case against router in its own subtest.
+func Run(t *testing.T, router *routing.Router, cases []Case) {
+	t.Helper()
+	for i, c := range cases {
+		c := c
+		req := c.request()
+		t.Run(fmt.Sprintf("%d %s %s", i, req.Method, req.URL.Path), func(t *testing.T) {
+			t.Helper()
+			if diff := check(router, req, c); diff != "" {
+				t.Errorf("%s %s:\n%s", req.Method, req.URL, diff)
+			}
+		})
+	}
+}
+
+// request builds the request described by the case.
+func (c Case) request() *http.Request {
+	method := c.Method
+	if method == "" {
+		method = "GET"
+	}
+	target := c.URL
+	if strings.HasPrefix(target, "/") {
+		target = "http://example.com" + target
+	}
+	req := httptest.NewRequest(method, target, nil)
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	return req
+}
+
+// check resolves req with router, and returns a description of how the
+// result differs from c, or an empty string if it does not.
+func check(router *routing.Router, req *http.Request, c Case) string {
+	m, err := router.Match(req)
+	var diff []string
+	if err != c.Err {
+		diff = append(diff, fmt.Sprintf("    error:    got %v, want %v", err, c.Err))
+	}
+	if m == nil {
+		m = new(routing.RouteMatch)
+	}
+	if got := routeName(m.Route); got != c.Route && (c.Err == nil || c.Route != "") {
+		diff = append(diff, fmt.Sprintf("    route:    got %q, want %q", got, c.Route))
+	}
+	if m.Redirect != c.Redirect {
+		diff = append(diff, fmt.Sprintf("    redirect: got %q, want %q", m.Redirect, c.Redirect))
+	}
+	if c.Params != nil {
+		diff = append(diff, diffParams(m.Params, c.Params)...)
+	}
+	return strings.Join(diff, "\n")
+}
+
+// routeName returns the name of route, or its path if it has no name.
+func routeName(route *routing.Route) string {
+	if route == nil {
+		return ""
+	}
+	if name := route.Name(); name != "" {
+		return name
+	}
+	return route.Path()
+}
+
+// diffParams returns a line for each param that differs between got and
+// want.
+func diffParams(got, want map[string]string) []string {
+	keys := make(map[string]bool)
+	for k := range got {
+		keys[k] = true
+	}
+	for k := range want {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var diff []string
+	for _, k := range sorted {
+		g, inGot := got[k]
+		w, inWant := want[k]
+		switch {
+		case inGot && !inWant:
+			diff = append(diff, fmt.Sprintf("    param %s: got %q, want none", k, g))
+		case !inGot && inWant:
+			diff = append(diff, fmt.Sprintf("    param %s: got none, want %q", k, w))
+		case g != w:
+			diff = append(diff, fmt.Sprintf("    param %s: got %q, want %q", k, g, w))
+		}
+	}
+	return diff
+}
@@ -0,0 +1,83 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routingtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	routing "github.com/timewasted/go-routing"
+)
+
+// errorRecorder is a testing.TB that records reported errors.
+type errorRecorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *errorRecorder) Helper() {}
+
+func (r *errorRecorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func newTestRouter() *routing.Router {
+	handler := func(w http.ResponseWriter, req *routing.Request) {}
+	router := routing.NewRouter()
+	router.NewRoute().SetName("user").SetPath("/users/{id:[0-9]+}").SetMethods("GET").SetHandler(handler)
+	router.NewRoute().SetMatchSlashes(true).SetPath("/docs/").SetHandler(handler)
+	return router
+}
+
+func TestRun(t *testing.T) {
+	Run(t, newTestRouter(), []Case{
+		{URL: "/users/42", Route: "user", Params: map[string]string{"id": "42"}},
+		{Method: "POST", URL: "/users/42", Err: routing.ErrMethodNotAllowed},
+		{URL: "/docs", Route: "/docs/", Redirect: "/docs/"},
+		{URL: "http://example.org/missing", Err: routing.ErrNotFound},
+	})
+}
+
+func TestAssertRoute(t *testing.T) {
+	router := newTestRouter()
+
+	r := new(errorRecorder)
+	AssertRoute(r, router, httptest.NewRequest("GET", "/users/42", nil), "user", map[string]string{"id": "42"})
+	if len(r.errors) != 0 {
+		t.Errorf("Expected no errors, received '%v'.", r.errors)
+	}
+
+	r = new(errorRecorder)
+	AssertRoute(r, router, httptest.NewRequest("GET", "/users/42", nil), "account", map[string]string{"id": "41", "tab": "posts"})
+	if len(r.errors) != 1 {
+		t.Fatalf("Expected 1 error, received '%v'.", r.errors)
+	}
+	expected := []string{
+		`GET /users/42:`,
+		`    route:    got "user", want "account"`,
+		`    param id: got "42", want "41"`,
+		`    param tab: got none, want "posts"`,
+	}
+	if r.errors[0] != strings.Join(expected, "\n") {
+		t.Errorf("Expected error '%v', received '%v'.", strings.Join(expected, "\n"), r.errors[0])
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	handler := func(w http.ResponseWriter, req *routing.Request) {
+		w.Write([]byte(req.Request.Method + " " + req.Params["id"]))
+	}
+	w := Serve(handler, NewRequest("DELETE", "/users/42", map[string]string{"id": "42"}))
+	if w.Body.String() != "DELETE 42" {
+		t.Errorf("Expected body 'DELETE 42', received '%v'.", w.Body.String())
+	}
+
+	if req := NewRequest("GET", "/", nil); req.Params == nil {
+		t.Error("Expected empty params, received nil.")
+	}
+}
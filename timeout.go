@@ -0,0 +1,307 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SetTimeout sets a timeout that will be applied to all newly created routes.
+// See Route.SetTimeout for a description of how this works.
+func (r *Router) SetTimeout(d time.Duration) *Router {
+	r.timeout = d
+	return r
+}
+
+// Timeout returns the timeout that will be applied to all newly created
+// routes.
+func (r *Router) Timeout() time.Duration {
+	return r.timeout
+}
+
+// UnsetTimeout clears the timeout that will be applied to all newly created
+// routes.
+func (r *Router) UnsetTimeout() {
+	r.timeout = 0
+}
+
+// SetTimeoutHandler sets the handler to be used when a route's handler has
+// not started writing a response by the time its timeout expires.  By
+// default, a 503 Service Unavailable response is sent.
+func (r *Router) SetTimeoutHandler(f http.HandlerFunc) *Router {
+	r.timeoutHandler = f
+	return r
+}
+
+// TimeoutHandler returns the handler used when a route's handler times out.
+func (r *Router) TimeoutHandler() http.HandlerFunc {
+	return r.timeoutHandler
+}
+
+// SetTimeout sets how long the route's handler has to respond to a request.
+// The handler is given a request whose context is cancelled once the timeout
+// expires.  If the handler has not started writing a response by then, the
+// router's timeout handler is called, and any further writes made by the
+// handler fail with http.ErrHandlerTimeout.  Otherwise, the response is left
+// to the handler to finish.  If the handler hijacks the connection, such as
+// with UpgradeWebSocket, the timeout is stopped.  If the request's context is
+// cancelled for any other reason, such as the client disconnecting, the
+// timeout handler is not called, and the handler is left to finish.
+//
+// In a Subroute tree, the timeout of the last matched route applies to the
+// handlers of the whole tree.
+func (r *Route) SetTimeout(d time.Duration) *Route {
+	r.timeout = d
+	return r
+}
+
+// Timeout returns how long the route's handler has to respond to a request.
+func (r *Route) Timeout() time.Duration {
+	return r.timeout
+}
+
+// UnsetTimeout clears the route's timeout.
+func (r *Route) UnsetTimeout() {
+	r.timeout = 0
+}
+
+// withTimeout returns h wrapped so that it is given d to respond to a
+// request.
+func (r *Router) withTimeout(d time.Duration, h HandlerFunc) HandlerFunc {
+	return func(w http.ResponseWriter, req *Request) {
+		ctx, cancel := newTimeoutContext(req.Request.Context(), d)
+		defer cancel()
+		req.Request = req.Request.WithContext(ctx)
+
+		tw := &timeoutWriter{w: w, h: make(http.Header), stop: ctx.stop}
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			h(tw, req)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded && tw.timeout() {
+				if r.timeoutHandler != nil {
+					r.timeoutHandler(w, req.Request)
+				} else {
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				}
+				return
+			}
+			// The response has already started, or the request was cancelled
+			// by the client or a parent context rather than timing out, so
+			// the handler is left to finish.
+			select {
+			case p := <-panicked:
+				panic(p)
+			case <-done:
+			}
+		}
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		if !tw.wroteHeader {
+			copyHeader(w.Header(), tw.h)
+		}
+	}
+}
+
+// timeoutWriter is a http.ResponseWriter that stops passing writes on to the
+// underlying writer once the handler using it has timed out.
+type timeoutWriter struct {
+	w           http.ResponseWriter
+	h           http.Header
+	mu          sync.Mutex
+	timedOut    bool
+	wroteHeader bool
+	stop        func() bool // Stops the timeout, if it has not expired yet
+}
+
+// Header returns the header map that will be sent with the response.
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+// WriteHeader sends the response header, unless the handler has timed out.
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.writeHeader(code)
+}
+
+// Write writes p to the response, unless the handler has timed out.
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeader(http.StatusOK)
+	}
+	return tw.w.Write(p)
+}
+
+// Flush sends any buffered data to the client, if the underlying writer
+// supports it.
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		if !tw.wroteHeader {
+			tw.writeHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection, if the underlying writer
+// supports it.  The timeout is stopped, since the handler is then responsible
+// for the connection.  If the timeout has already expired,
+// http.ErrHandlerTimeout is returned.
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || !tw.stop() {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	conn, rw, err := http.NewResponseController(tw.w).Hijack()
+	if err == nil {
+		// Leave the handler to finish with the connection.
+		tw.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// timeout marks the handler as timed out, unless it has already started its
+// response, and returns whether it did so.
+func (tw *timeoutWriter) timeout() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.wroteHeader {
+		return false
+	}
+	tw.timedOut = true
+	return true
+}
+
+// writeHeader sends the response header.  tw.mu must be held.
+func (tw *timeoutWriter) writeHeader(code int) {
+	tw.wroteHeader = true
+	copyHeader(tw.w.Header(), tw.h)
+	tw.w.WriteHeader(code)
+}
+
+// copyHeader copies every header in src to dst.
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// timeoutContext is a context that expires after a timeout, like one returned
+// by context.WithTimeout, except that the timeout can be stopped.  It closes
+// its own Done channel, rather than sharing that of the embedded context, so
+// that contexts derived from it take their error from its Err method, and see
+// context.DeadlineExceeded once the timeout expires.
+type timeoutContext struct {
+	context.Context // Cancelled, with the cause, once the context is done
+	done            chan struct{}
+	mu              sync.Mutex
+	deadline        time.Time
+	timer           *time.Timer
+	err             error
+}
+
+// newTimeoutContext returns a new timeoutContext that expires after d, and a
+// function that cancels it.
+func newTimeoutContext(parent context.Context, d time.Duration) (*timeoutContext, context.CancelFunc) {
+	inner, cancel := context.WithCancelCause(parent)
+	ctx := &timeoutContext{Context: inner, done: make(chan struct{}), deadline: time.Now().Add(d)}
+	context.AfterFunc(inner, func() {
+		close(ctx.done)
+	})
+	ctx.timer = time.AfterFunc(d, func() {
+		ctx.mu.Lock()
+		ctx.err = context.DeadlineExceeded
+		ctx.mu.Unlock()
+		cancel(context.DeadlineExceeded)
+	})
+	return ctx, func() {
+		ctx.timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// stop stops the timeout, and returns false if it has already expired.
+func (ctx *timeoutContext) stop() bool {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.deadline.IsZero() {
+		// Already stopped.
+		return true
+	}
+	if !ctx.timer.Stop() {
+		return false
+	}
+	ctx.deadline = time.Time{}
+	return true
+}
+
+// Deadline returns when the timeout expires, unless it has been stopped.
+func (ctx *timeoutContext) Deadline() (time.Time, bool) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.deadline.IsZero() {
+		return ctx.Context.Deadline()
+	}
+	if d, ok := ctx.Context.Deadline(); ok && d.Before(ctx.deadline) {
+		return d, true
+	}
+	return ctx.deadline, true
+}
+
+// Done returns a channel that is closed once the timeout expires, or the
+// parent context is done.
+func (ctx *timeoutContext) Done() <-chan struct{} {
+	return ctx.done
+}
+
+// Err returns context.DeadlineExceeded if the timeout expired, and otherwise
+// the error of the parent context.
+func (ctx *timeoutContext) Err() error {
+	select {
+	case <-ctx.done:
+	default:
+		return nil
+	}
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.err != nil {
+		return ctx.err
+	}
+	return ctx.Context.Err()
+}
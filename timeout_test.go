@@ -0,0 +1,180 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRouteTimeout(t *testing.T) {
+	router := NewRouter()
+	if router.Timeout() != 0 {
+		t.Errorf("Expected no timeout, received '%v'.", router.Timeout())
+	}
+
+	// New routes use the router's default.
+	router.SetTimeout(time.Second)
+	route := router.NewRoute()
+	if route.Timeout() != time.Second {
+		t.Errorf("Expected timeout '1s', received '%v'.", route.Timeout())
+	}
+
+	// Subroutes inherit the timeout, and can override it.
+	child := route.Subroute()
+	if child.Timeout() != time.Second {
+		t.Errorf("Expected timeout '1s', received '%v'.", child.Timeout())
+	}
+	child.SetTimeout(time.Minute)
+	if child.Timeout() != time.Minute {
+		t.Errorf("Expected timeout '1m0s', received '%v'.", child.Timeout())
+	}
+	if route.Timeout() != time.Second {
+		t.Errorf("Expected timeout '1s', received '%v'.", route.Timeout())
+	}
+
+	route.UnsetTimeout()
+	if route.Timeout() != 0 {
+		t.Errorf("Expected no timeout, received '%v'.", route.Timeout())
+	}
+	router.UnsetTimeout()
+	if router.Timeout() != 0 {
+		t.Errorf("Expected no timeout, received '%v'.", router.Timeout())
+	}
+}
+
+func TestRouteTimeout_request(t *testing.T) {
+	router := NewRouter()
+	release := make(chan struct{})
+	writeErr := make(chan error, 1)
+	router.NewRoute().SetPath("/slow").SetTimeout(10 * time.Millisecond).SetHandler(func(w http.ResponseWriter, req *Request) {
+		<-req.Request.Context().Done()
+		<-release
+		w.Header().Set("X-Handler", "slow")
+		_, err := w.Write([]byte("too late"))
+		writeErr <- err
+	})
+	router.NewRoute().SetPath("/fast").SetTimeout(time.Minute).SetHandler(func(w http.ResponseWriter, req *Request) {
+		w.Header().Set("X-Handler", "fast")
+		w.WriteHeader(http.StatusCreated)
+	})
+	router.NewRoute().SetPath("/started").SetTimeout(10 * time.Millisecond).SetHandler(func(w http.ResponseWriter, req *Request) {
+		w.Write([]byte("started"))
+		<-req.Request.Context().Done()
+		w.Write([]byte(" finished"))
+	})
+
+	// A handler that has not written by the deadline gets a 503.
+	req, _ := http.NewRequest("GET", "http://example.com/slow", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	release <- struct{}{}
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected code '503', received '%v'.", w.Code)
+	}
+	if err := <-writeErr; err != http.ErrHandlerTimeout {
+		t.Errorf("Expected error '%v', received '%v'.", http.ErrHandlerTimeout, err)
+	}
+	if w.Header().Get("X-Handler") != "" || w.Body.String() == "too late" {
+		t.Error("Expected the handler's response to be discarded.")
+	}
+
+	// A custom response can be used instead.
+	router.SetTimeoutHandler(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+	})
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	release <- struct{}{}
+	<-writeErr
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected code '504', received '%v'.", w.Code)
+	}
+
+	// Handlers that finish in time are unaffected.
+	req, _ = http.NewRequest("GET", "http://example.com/fast", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected code '201', received '%v'.", w.Code)
+	}
+	if w.Header().Get("X-Handler") != "fast" {
+		t.Errorf("Expected header 'fast', received '%v'.", w.Header().Get("X-Handler"))
+	}
+
+	// Handlers that have started their response are left to finish it.
+	req, _ = http.NewRequest("GET", "http://example.com/started", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected code '200', received '%v'.", w.Code)
+	}
+	if w.Body.String() != "started finished" {
+		t.Errorf("Expected body 'started finished', received '%v'.", w.Body.String())
+	}
+}
+
+func TestRouteTimeout_cancel(t *testing.T) {
+	router := NewRouter()
+	router.NewRoute().SetPath("/").SetTimeout(time.Minute).SetHandler(func(w http.ResponseWriter, req *Request) {
+		<-req.Request.Context().Done()
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// Cancelling the request is not a timeout.
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://example.com/", nil)
+	w := httptest.NewRecorder()
+	time.AfterFunc(10*time.Millisecond, cancel)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected code '204', received '%v'.", w.Code)
+	}
+}
+
+func TestRouteTimeout_derivedContext(t *testing.T) {
+	router := NewRouter()
+	errs := make(chan error, 2)
+	router.NewRoute().SetPath("/").SetTimeout(10 * time.Millisecond).SetHandler(func(w http.ResponseWriter, req *Request) {
+		ctx, cancel := context.WithCancel(req.Request.Context())
+		defer cancel()
+		<-ctx.Done()
+		errs <- ctx.Err()
+		errs <- context.Cause(ctx)
+	})
+
+	// Contexts derived from the request's see that the deadline was exceeded.
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if err := <-errs; err != context.DeadlineExceeded {
+		t.Errorf("Expected error '%v', received '%v'.", context.DeadlineExceeded, err)
+	}
+	if err := <-errs; err != context.DeadlineExceeded {
+		t.Errorf("Expected cause '%v', received '%v'.", context.DeadlineExceeded, err)
+	}
+}
+
+func TestRouteTimeout_subroute(t *testing.T) {
+	router := NewRouter()
+	parent := router.NewRoute().SetPrefix("/api/").SetTimeout(10 * time.Millisecond)
+	parent.SetHandler(func(w http.ResponseWriter, req *Request) {
+		req.Next(w)
+	})
+	parent.Subroute().SetPath("/report").SetTimeout(time.Minute).SetHandler(func(w http.ResponseWriter, req *Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	// The child's timeout overrides its parent's.
+	req, _ := http.NewRequest("GET", "http://example.com/api/report", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected code '204', received '%v'.", w.Code)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// traceKey is the context key under which the TraceContext of the current
+// span is stored.
+type traceKey struct{}
+
+// A Tracer records spans for the requests handled by a router, and exports
+// them to a tracing backend.
+type Tracer interface {
+	// StartSpan is called once the router has matched a request against its
+	// routes, before any handler is called.  The returned context is used as
+	// the request's context while it is handled, and the returned Span is
+	// ended once the response is complete.
+	StartSpan(ctx context.Context, info SpanInfo) (context.Context, Span)
+}
+
+// A Span is a single traced request.
+type Span interface {
+	// End is called once the response is complete.
+	End(outcome SpanOutcome)
+}
+
+// SpanInfo describes a request as it was matched by the router.
+type SpanInfo struct {
+	// Name is the method, followed by the path template of the matched route
+	// if there is one, such as "GET /users/{id:[0-9]+}".
+	Name string
+	// RouteName is the name of the matched route, if any.
+	RouteName string
+	// Template is the path template of the matched route, if any.  If the
+	// route's router is mounted on another router, the template includes
+	// the mount point.
+	Template string
+	// Method is the request's method.
+	Method string
+	// Params holds the parameters of the matched route.
+	Params map[string]string
+	// Parent is the trace context received in the traceparent and
+	// tracestate headers.  It is not valid if the headers were missing or
+	// invalid.
+	Parent TraceContext
+	// Trace is the trace context of the new span.  It continues the trace of
+	// Parent if it is valid, and starts a new trace otherwise.
+	Trace TraceContext
+	// Start is when the span started.
+	Start time.Time
+}
+
+// SpanOutcome describes how a request was handled.
+type SpanOutcome struct {
+	// Matched is true if a route matched the request.
+	Matched bool
+	// Status is the status code of the response.
+	Status int
+	// Duration is how long the request took to handle.
+	Duration time.Duration
+	// Panic holds the value passed to panic if a handler panicked.  The
+	// panic continues once the span has ended.
+	Panic interface{}
+}
+
+// A TraceContext identifies a span within a trace, as defined by the W3C
+// Trace Context specification.
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	// State holds the vendor specific tracestate header, if any.
+	State string
+}
+
+// ParseTraceParent parses the value of a traceparent header.  If the value is
+// not valid, false is returned.
+func ParseTraceParent(s string) (TraceContext, bool) {
+	var tc TraceContext
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return tc, false
+	}
+	version, ok := decodeLowerHex(s[:2])
+	if !ok || version[0] == 0xff {
+		return tc, false
+	}
+	// Future versions may append fields, but version 00 may not.
+	if len(s) > 55 && (version[0] == 0 || s[55] != '-') {
+		return tc, false
+	}
+	traceID, ok := decodeLowerHex(s[3:35])
+	if !ok {
+		return tc, false
+	}
+	spanID, ok := decodeLowerHex(s[36:52])
+	if !ok {
+		return tc, false
+	}
+	flags, ok := decodeLowerHex(s[53:55])
+	if !ok {
+		return tc, false
+	}
+	copy(tc.TraceID[:], traceID)
+	copy(tc.SpanID[:], spanID)
+	tc.Flags = flags[0]
+	if !tc.IsValid() {
+		return TraceContext{}, false
+	}
+	return tc, true
+}
+
+// IsValid returns true if neither the trace ID nor the span ID is all zeros.
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// Sampled returns true if the sampled flag is set.
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&0x01 != 0
+}
+
+// TraceParent returns the trace context formatted as a traceparent header.
+func (tc TraceContext) TraceParent() string {
+	return "00-" + hex.EncodeToString(tc.TraceID[:]) + "-" + hex.EncodeToString(tc.SpanID[:]) + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+// Inject sets the traceparent and tracestate headers in h, so that the trace
+// is continued by outgoing requests.
+func (tc TraceContext) Inject(h http.Header) {
+	h.Set("Traceparent", tc.TraceParent())
+	if tc.State != "" {
+		h.Set("Tracestate", tc.State)
+	} else {
+		h.Del("Tracestate")
+	}
+}
+
+// SetTracer sets the tracer that records spans for each request.
+func (r *Router) SetTracer(t Tracer) *Router {
+	r.tracer = t
+	return r
+}
+
+// Tracer returns the tracer that records spans for each request.
+func (r *Router) Tracer() Tracer {
+	return r.tracer
+}
+
+// UnsetTracer clears the tracer that records spans for each request.
+func (r *Router) UnsetTracer() {
+	r.tracer = nil
+}
+
+// TraceContext returns the trace context of the span recording the request.
+// If the router has no tracer, false is returned.
+func (r *Request) TraceContext() (TraceContext, bool) {
+	tc, ok := r.Request.Context().Value(traceKey{}).(TraceContext)
+	return tc, ok
+}
+
+// startSpan starts a span for the request matched to route, which may be nil.
+// It returns the writer and request to use while handling the request, and a
+// function that must be deferred to end the span.
+func (r *Router) startSpan(w http.ResponseWriter, req *http.Request, route *Route, params []map[string]string) (http.ResponseWriter, *http.Request, func()) {
+	info := SpanInfo{
+		Name:   req.Method,
+		Method: req.Method,
+		Start:  time.Now(),
+	}
+	if route != nil {
+		info.RouteName = route.Name()
+		info.Template = routeTemplate(route)
+		if info.Template != "" {
+			info.Name += " " + info.Template
+		}
+		info.Params = params[len(params)-1]
+	}
+
+	if parent, ok := ParseTraceParent(req.Header.Get("Traceparent")); ok {
+		parent.State = strings.Join(req.Header.Values("Tracestate"), ",")
+		info.Parent = parent
+		info.Trace = parent
+	} else {
+		rand.Read(info.Trace.TraceID[:])
+		info.Trace.Flags = 0x01
+	}
+	rand.Read(info.Trace.SpanID[:])
+
+	ctx, span := r.tracer.StartSpan(context.WithValue(req.Context(), traceKey{}, info.Trace), info)
+	req = req.WithContext(ctx)
+	sw := &statusWriter{ResponseWriter: w}
+
+	return sw, req, func() {
+		outcome := SpanOutcome{
+			Matched:  route != nil,
+			Status:   sw.status,
+			Duration: time.Since(info.Start),
+			Panic:    recover(),
+		}
+		if outcome.Status == 0 {
+			outcome.Status = http.StatusOK
+		}
+		span.End(outcome)
+		if outcome.Panic != nil {
+			panic(outcome.Panic)
+		}
+	}
+}
+
+// routeTemplate returns the path template of route, including the paths of
+// any mount points its router is mounted on.
+func routeTemplate(route *Route) string {
+	template := route.Path()
+	for m := route.router.mount; m != nil; m = m.router.mount {
+		if p := strings.TrimSuffix(m.Path(), "/"); p != "" {
+			template = p + template
+		}
+	}
+	return template
+}
+
+// decodeLowerHex decodes s, which must only contain lower case hexadecimal
+// digits.
+func decodeLowerHex(s string) ([]byte, bool) {
+	if strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
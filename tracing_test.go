@@ -0,0 +1,137 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// recordingTracer keeps the spans it starts.
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+type recordingSpan struct {
+	info    SpanInfo
+	outcome *SpanOutcome
+}
+
+func (t *recordingTracer) StartSpan(ctx context.Context, info SpanInfo) (context.Context, Span) {
+	span := &recordingSpan{info: info}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *recordingSpan) End(outcome SpanOutcome) {
+	s.outcome = &outcome
+}
+
+func TestParseTraceParent(t *testing.T) {
+	type traceParentTest struct {
+		value string
+		valid bool
+	}
+
+	tests := []traceParentTest{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},        // 0
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},        // 1
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true},  // 2
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false}, // 3
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},       // 4
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},       // 5
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},       // 6
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},       // 7
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},          // 8
+		{"", false}, // 9
+	}
+
+	for i, test := range tests {
+		tc, ok := ParseTraceParent(test.value)
+		if ok != test.valid {
+			t.Errorf("tests[%v]: Expected valid to be '%v', received '%v'.", i, test.valid, ok)
+			continue
+		}
+		if ok && tc.TraceParent() != "00"+test.value[2:55] {
+			t.Errorf("tests[%v]: Expected '%v', received '%v'.", i, "00"+test.value[2:55], tc.TraceParent())
+		}
+	}
+}
+
+func TestRouterTracer(t *testing.T) {
+	tracer := new(recordingTracer)
+	router := NewRouter().SetTracer(tracer)
+	if router.Tracer() != tracer {
+		t.Errorf("Expected tracer '%v', received '%v'.", tracer, router.Tracer())
+	}
+
+	outgoing := make(http.Header)
+	router.NewRoute().SetName("user").SetPath("/users/{id:[0-9]+}").SetHandler(func(w http.ResponseWriter, req *Request) {
+		tc, ok := req.TraceContext()
+		if !ok {
+			t.Error("Expected a trace context, received none.")
+		}
+		tc.Inject(outgoing)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	api := NewRouter().SetTracer(tracer)
+	api.NewRoute().SetPath("/items/{id:[0-9]+}").SetHandler(func(w http.ResponseWriter, req *Request) {
+		panic("oops")
+	})
+	router.Mount("/api", api)
+
+	// Incoming trace contexts are continued.
+	req, _ := http.NewRequest("GET", "http://example.com/users/42", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Tracestate", "vendor=value")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	span := tracer.spans[0]
+	if span.info.Name != "GET /users/{id:[0-9]+}" {
+		t.Errorf("Expected name 'GET /users/{id:[0-9]+}', received '%v'.", span.info.Name)
+	}
+	if span.info.RouteName != "user" || span.info.Params["id"] != "42" {
+		t.Errorf("Expected route 'user' and id '42', received '%v' and '%v'.", span.info.RouteName, span.info.Params)
+	}
+	if span.info.Trace.TraceID != span.info.Parent.TraceID || span.info.Trace.SpanID == span.info.Parent.SpanID {
+		t.Errorf("Expected the trace to continue with a new span, received '%v'.", span.info.Trace)
+	}
+	if span.outcome == nil || !span.outcome.Matched || span.outcome.Status != http.StatusAccepted {
+		t.Errorf("Expected a matched outcome with status '202', received '%+v'.", span.outcome)
+	}
+	if outgoing.Get("Traceparent") != span.info.Trace.TraceParent() || outgoing.Get("Tracestate") != "vendor=value" {
+		t.Errorf("Expected the trace context to be propagated, received '%v'.", outgoing)
+	}
+
+	// Unmatched requests start a new trace.
+	req, _ = http.NewRequest("POST", "http://example.com/missing", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	span = tracer.spans[1]
+	if span.info.Name != "POST" || span.info.Parent.IsValid() || !span.info.Trace.IsValid() {
+		t.Errorf("Expected a new unnamed trace, received '%+v'.", span.info)
+	}
+	if span.outcome.Matched || span.outcome.Status != http.StatusNotFound {
+		t.Errorf("Expected an unmatched outcome with status '404', received '%+v'.", span.outcome)
+	}
+
+	// Mounted routers include the mount point, and panics are recorded.
+	req, _ = http.NewRequest("GET", "http://example.com/api/items/1", nil)
+	func() {
+		defer func() {
+			if p := recover(); p != "oops" {
+				t.Errorf("Expected panic 'oops', received '%v'.", p)
+			}
+		}()
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	span = tracer.spans[len(tracer.spans)-1]
+	if span.info.Template != "/api/items/{id:[0-9]+}" {
+		t.Errorf("Expected template '/api/items/{id:[0-9]+}', received '%v'.", span.info.Template)
+	}
+	if span.outcome == nil || span.outcome.Panic != "oops" {
+		t.Errorf("Expected panic 'oops', received '%+v'.", span.outcome)
+	}
+}
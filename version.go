@@ -0,0 +1,436 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error messages related to versioning.
+const (
+	errInvalidVersion      = "routing: '%s' is not a valid version."
+	errInvalidVersionRange = "routing: '%s' is not a valid version range."
+)
+
+// A VersionStrategy is a set of places that the requested API version is
+// read from.
+type VersionStrategy int
+
+// The places that the requested API version can be read from.  When more
+// than one is used, they are checked in the order listed here.
+const (
+	// VersionPath reads the version from a path prefix, such as "/v2".  The
+	// prefix is removed from the path before routes are matched.
+	VersionPath VersionStrategy = 1 << iota
+	// VersionHeader reads the version from a header, "Api-Version" by
+	// default.
+	VersionHeader
+	// VersionAccept reads the version from the "version" parameter of a
+	// media type in the Accept header, such as
+	// "application/vnd.example+json;version=2".
+	VersionAccept
+)
+
+// versionKey is the context key under which versionInfo is stored.
+type versionKey struct{}
+
+// versionInfo holds the API version that a request asked for, and the
+// version that it was resolved to.
+type versionInfo struct {
+	requested *Version
+	resolved  *Version
+	vary      []string // Headers that were checked for the requested version
+}
+
+// A Version is an API version, consisting of a major and minor number.
+type Version struct {
+	Major, Minor int
+}
+
+// versionMax is used as the minor number of versions that include every
+// minor version, and the major number of versions with no upper bound.
+const versionMax = math.MaxInt32
+
+// versionPathRegexp matches a version path prefix, such as "/v2" or "/v2.1".
+var versionPathRegexp = regexp.MustCompile(`^/[vV]([0-9]+(?:\.[0-9]+)?)(?:/|$)`)
+
+// ParseVersion parses a version such as "2", "2.1", or "v2.1".
+func ParseVersion(s string) (Version, error) {
+	v, anyMinor, err := parseVersion(s)
+	if err != nil {
+		return Version{}, err
+	}
+	if anyMinor {
+		v.Minor = 0
+	}
+	return v, nil
+}
+
+// String returns the version as "major.minor", or just "major" if the minor
+// number is zero.
+func (v Version) String() string {
+	if v.Minor == 0 {
+		return strconv.Itoa(v.Major)
+	}
+	return strconv.Itoa(v.Major) + "." + strconv.Itoa(v.Minor)
+}
+
+// less returns true if v comes before o.
+func (v Version) less(o Version) bool {
+	return v.Major < o.Major || (v.Major == o.Major && v.Minor < o.Minor)
+}
+
+// versionRange is an inclusive range of versions.
+type versionRange struct {
+	raw       string
+	low, high Version
+}
+
+// SetVersioning sets where the requested API version is read from.  Routes
+// with a version set only match requests for a version within their range.
+// If none do, the route serving the latest version with the same major
+// number as the requested version is used, or the latest version overall if
+// no version was requested.
+//
+// The version that a request was resolved to is available from
+// Request.Version.
+func (r *Router) SetVersioning(s VersionStrategy) *Router {
+	r.versioning = s
+	return r
+}
+
+// Versioning returns where the requested API version is read from.
+func (r *Router) Versioning() VersionStrategy {
+	return r.versioning
+}
+
+// SetVersionHeader sets the name of the header that the requested API
+// version is read from when using VersionHeader.  The default is
+// "Api-Version".
+func (r *Router) SetVersionHeader(h string) *Router {
+	r.versionHeader = h
+	return r
+}
+
+// VersionHeader returns the name of the header that the requested API
+// version is read from when using VersionHeader.
+func (r *Router) VersionHeader() string {
+	return r.versionHeader
+}
+
+// A DeprecationFunc is called when a request is resolved to a deprecated API
+// version v, before any handlers are called.  It can set response headers,
+// such as Deprecation, Sunset, or Link, and can use req.Route to apply a
+// different policy to each route.
+type DeprecationFunc func(w http.ResponseWriter, req *Request, v Version)
+
+// DeprecateVersion marks the versions in the range v as deprecated.  When a
+// request is resolved to one of those versions, the response will include a
+// Deprecation header if deprecation is not zero, and a Sunset header if
+// sunset is not zero.  See Route.SetVersion for the format of v.  If parsing
+// of v fails, an error message is set on the router.
+//
+// This is the same as calling DeprecateVersionFunc with the DeprecationFunc
+// returned by DeprecationHeaders.
+func (r *Router) DeprecateVersion(v string, deprecation, sunset time.Time) *Router {
+	return r.DeprecateVersionFunc(v, DeprecationHeaders(deprecation, sunset))
+}
+
+// DeprecateVersionFunc marks the versions in the range v as deprecated.  When
+// a request is resolved to one of those versions, f is called.  If more than
+// one range contains the version, only the first one marked is used.  See
+// Route.SetVersion for the format of v.  If parsing of v fails, an error
+// message is set on the router.
+func (r *Router) DeprecateVersionFunc(v string, f DeprecationFunc) *Router {
+	versions, err := parseVersionRange(v)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.deprecations = append(r.deprecations, versionDeprecation{
+		versions: versions,
+		f:        f,
+	})
+	return r
+}
+
+// DeprecationHeaders returns a DeprecationFunc that sets a Deprecation header
+// if deprecation is not zero, and a Sunset header if sunset is not zero.
+func DeprecationHeaders(deprecation, sunset time.Time) DeprecationFunc {
+	return func(w http.ResponseWriter, req *Request, v Version) {
+		if !deprecation.IsZero() {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", deprecation.Unix()))
+		}
+		if !sunset.IsZero() {
+			w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+	}
+}
+
+// versionDeprecation holds the function called for a range of deprecated
+// versions.
+type versionDeprecation struct {
+	versions *versionRange
+	f        DeprecationFunc
+}
+
+// SetVersion sets the range of API versions that the route serves.  The range
+// is either a single version such as "2" or "2.1", or two versions separated
+// by a hyphen, such as "1-2.3".  Either side of the hyphen can be left empty
+// for a range with no lower or upper bound.  A version without a minor
+// number covers every minor version, so "2" is the same as "2.0-2.x".  If
+// parsing of the range fails, no version is set, and an error message is set
+// on the route.
+func (r *Route) SetVersion(v string) *Route {
+	versions, err := parseVersionRange(v)
+	if err != nil {
+		r.err = err
+		return r
+	}
+	r.version = versions
+	return r
+}
+
+// Version returns the range of API versions that the route serves.
+func (r *Route) Version() string {
+	if r.version == nil {
+		return ""
+	}
+	return r.version.raw
+}
+
+// UnsetVersion clears the range of API versions that the route serves.
+func (r *Route) UnsetVersion() {
+	r.version = nil
+}
+
+// Version returns the API version that the request was resolved to.  If the
+// request did not ask for a version, and was not matched by a versioned
+// route, false is returned.
+func (r *Request) Version() (Version, bool) {
+	info, ok := r.Request.Context().Value(versionKey{}).(*versionInfo)
+	if !ok || info.resolved == nil {
+		return Version{}, false
+	}
+	return *info.resolved, true
+}
+
+// versioned returns req with the requested version added to its context,
+// and any version path prefix removed.  If versioning is not enabled, req is
+// returned unchanged.
+func (r *Router) versioned(req *http.Request) *http.Request {
+	if r.versioning == 0 {
+		return req
+	}
+
+	info := new(versionInfo)
+	stripped := req
+	if r.versioning&VersionPath != 0 {
+		if m := versionPathRegexp.FindStringSubmatch(req.URL.Path); m != nil {
+			if v, err := ParseVersion(m[1]); err == nil {
+				info.requested = &v
+				prefix := strings.TrimSuffix(m[0], "/")
+				stripped = req.WithContext(context.WithValue(req.Context(), mountPrefixKey{}, mountPrefix(req)+prefix))
+				stripped.URL = new(url.URL)
+				*stripped.URL = *req.URL
+				stripped.URL.Path = req.URL.Path[len(prefix):]
+				if stripped.URL.Path == "" {
+					stripped.URL.Path = "/"
+				}
+				stripped.URL.RawPath = stripRawPrefix(req.URL.RawPath, prefix)
+			}
+		}
+	}
+	if info.requested == nil && r.versioning&VersionHeader != 0 {
+		info.vary = append(info.vary, r.versionHeader)
+		if v, err := ParseVersion(strings.TrimSpace(req.Header.Get(r.versionHeader))); err == nil {
+			info.requested = &v
+		}
+	}
+	if info.requested == nil && r.versioning&VersionAccept != 0 {
+		info.vary = append(info.vary, "Accept")
+		for _, accept := range splitHeader(req.Header["Accept"]) {
+			_, params, err := mime.ParseMediaType(accept)
+			if err != nil || params["version"] == "" {
+				continue
+			}
+			if v, err := ParseVersion(params["version"]); err == nil {
+				info.requested = &v
+				break
+			}
+		}
+	}
+	return stripped.WithContext(context.WithValue(stripped.Context(), versionKey{}, info))
+}
+
+// resolveVersion determines the version that route serves for req, and adds
+// it to the request's context.  If the version is deprecated, the
+// DeprecationFunc for it is called with params as the route's parameters.
+func (r *Router) resolveVersion(w http.ResponseWriter, req *http.Request, route *Route, params map[string]string) *http.Request {
+	info, ok := req.Context().Value(versionKey{}).(*versionInfo)
+	if !ok {
+		return req
+	}
+	resolved := info.requested
+	if route.version != nil && (resolved == nil || !route.version.contains(*resolved)) {
+		if v, ok := route.version.latest(info.requested); ok {
+			resolved = &v
+		}
+	}
+	if len(info.vary) > 0 {
+		w.Header().Add("Vary", strings.Join(info.vary, ", "))
+	}
+	if resolved == nil {
+		return req
+	}
+
+	req = req.WithContext(context.WithValue(req.Context(), versionKey{}, &versionInfo{
+		requested: info.requested,
+		resolved:  resolved,
+		vary:      info.vary,
+	}))
+	for _, d := range r.deprecations {
+		if d.versions.contains(*resolved) {
+			d.f(w, &Request{Request: req, Route: route, Params: params}, *resolved)
+			break
+		}
+	}
+	return req
+}
+
+// matchVersion returns true if the route matches the request.
+func (r *Route) matchVersion(req *http.Request) bool {
+	if r.version == nil {
+		return true
+	}
+	info, ok := req.Context().Value(versionKey{}).(*versionInfo)
+	return ok && info.requested != nil && r.version.contains(*info.requested)
+}
+
+// betterVersionFallback returns true if the route is a better fallback for
+// the request than current, which may be nil.
+func (r *Route) betterVersionFallback(req *http.Request, current *Route) bool {
+	if r.version == nil {
+		return false
+	}
+	var requested *Version
+	if info, ok := req.Context().Value(versionKey{}).(*versionInfo); ok {
+		requested = info.requested
+	}
+	top, ok := r.version.top(requested)
+	if !ok {
+		return false
+	}
+	if current == nil {
+		return true
+	}
+	currentTop, _ := current.version.top(requested)
+	return currentTop.less(top)
+}
+
+// contains returns true if v is within the range.
+func (vr *versionRange) contains(v Version) bool {
+	return !v.less(vr.low) && !vr.high.less(v)
+}
+
+// top returns the highest version in the range that is compatible with
+// requested, meaning that it has the same major number and is not lower.  If
+// requested is nil, every version is compatible.
+func (vr *versionRange) top(requested *Version) (Version, bool) {
+	top := vr.high
+	if requested != nil {
+		if limit := (Version{requested.Major, versionMax}); limit.less(top) {
+			top = limit
+		}
+		if top.less(*requested) || top.less(vr.low) {
+			return Version{}, false
+		}
+	}
+	return top, true
+}
+
+// latest returns the version that the range serves to requests for
+// requested.  This is the highest compatible version, or the lowest version
+// in the range if it has no upper bound.
+func (vr *versionRange) latest(requested *Version) (Version, bool) {
+	top, ok := vr.top(requested)
+	if !ok {
+		return Version{}, false
+	}
+	if top.Minor == versionMax {
+		if requested != nil && vr.low.less(*requested) {
+			return *requested, true
+		}
+		return vr.low, true
+	}
+	return top, true
+}
+
+// parseVersion parses a version such as "2", "2.1", or "v2.1".  If the
+// version does not include a minor number, anyMinor is true.
+func parseVersion(s string) (v Version, anyMinor bool, err error) {
+	raw := s
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	parts := strings.SplitN(s, ".", 2)
+	if v.Major, err = strconv.Atoi(parts[0]); err != nil || v.Major < 0 || v.Major >= versionMax {
+		return Version{}, false, fmt.Errorf(errInvalidVersion, raw)
+	}
+	if len(parts) == 1 {
+		return v, true, nil
+	}
+	if v.Minor, err = strconv.Atoi(parts[1]); err != nil || v.Minor < 0 || v.Minor >= versionMax {
+		return Version{}, false, fmt.Errorf(errInvalidVersion, raw)
+	}
+	return v, false, nil
+}
+
+// parseVersionRange parses a range of versions.  See Route.SetVersion for the
+// format.
+func parseVersionRange(s string) (*versionRange, error) {
+	vr := &versionRange{
+		raw:  s,
+		high: Version{versionMax, versionMax},
+	}
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf(errInvalidVersionRange, s)
+	}
+	lowStr, highStr := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		lowStr, highStr = s[:i], s[i+1:]
+		if lowStr == "" && highStr == "" {
+			return nil, fmt.Errorf(errInvalidVersionRange, s)
+		}
+	}
+	if lowStr = strings.TrimSpace(lowStr); lowStr != "" {
+		low, _, err := parseVersion(lowStr)
+		if err != nil {
+			return nil, fmt.Errorf(errInvalidVersionRange, s)
+		}
+		vr.low = low
+	}
+	if highStr = strings.TrimSpace(highStr); highStr != "" {
+		high, anyMinor, err := parseVersion(highStr)
+		if err != nil {
+			return nil, fmt.Errorf(errInvalidVersionRange, s)
+		}
+		if anyMinor {
+			high.Minor = versionMax
+		}
+		vr.high = high
+	}
+	if vr.high.less(vr.low) {
+		return nil, fmt.Errorf(errInvalidVersionRange, s)
+	}
+	return vr, nil
+}
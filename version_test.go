@@ -0,0 +1,344 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseVersion(t *testing.T) {
+	type versionTest struct {
+		version string
+		parsed  Version
+		valid   bool
+	}
+
+	tests := []versionTest{
+		{"2", Version{2, 0}, true},        // 0
+		{"2.1", Version{2, 1}, true},      // 1
+		{"v3", Version{3, 0}, true},       // 2
+		{"V3.10", Version{3, 10}, true},   // 3
+		{"", Version{}, false},            // 4
+		{"two", Version{}, false},         // 5
+		{"2.x", Version{}, false},         // 6
+		{"-1", Version{}, false},          // 7
+		{"2.1.3", Version{}, false},       // 8
+		{"99999999999", Version{}, false}, // 9
+	}
+
+	for i, test := range tests {
+		v, err := ParseVersion(test.version)
+		if test.valid && err != nil {
+			t.Errorf("tests[%v]: Expected no error, received '%v'.", i, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("tests[%v]: Expected an error, received none.", i)
+		}
+		if v != test.parsed {
+			t.Errorf("tests[%v]: Expected version '%v', received '%v'.", i, test.parsed, v)
+		}
+	}
+}
+
+func TestRouteVersion(t *testing.T) {
+	router := NewRouter()
+	route := router.NewRoute()
+
+	if route.Version() != "" {
+		t.Errorf("Expected no version, received '%v'.", route.Version())
+	}
+
+	for _, v := range []string{"", "-", "x", "3-2", "2.1-2.0", "1-two"} {
+		route.UnsetError()
+		route.SetVersion(v)
+		if route.Error() == nil {
+			t.Errorf("Expected an error for '%v', received none.", v)
+		}
+		if route.Version() != "" {
+			t.Errorf("Expected no version, received '%v'.", route.Version())
+		}
+	}
+
+	route.UnsetError()
+	route.SetVersion("1-2.3")
+	if route.Error() != nil {
+		t.Errorf("Expected no error, received '%v'.", route.Error())
+	}
+	if route.Version() != "1-2.3" {
+		t.Errorf("Expected version '1-2.3', received '%v'.", route.Version())
+	}
+
+	// Subroutes inherit the version.
+	if child := route.Subroute(); child.Version() != "1-2.3" {
+		t.Errorf("Expected version '1-2.3', received '%v'.", child.Version())
+	}
+
+	route.UnsetVersion()
+	if route.Version() != "" {
+		t.Errorf("Expected no version, received '%v'.", route.Version())
+	}
+}
+
+func TestVersionRange_contains(t *testing.T) {
+	type rangeTest struct {
+		versions string
+		version  Version
+		contains bool
+	}
+
+	tests := []rangeTest{
+		{"2", Version{2, 0}, true},        // 0
+		{"2", Version{2, 7}, true},        // 1
+		{"2", Version{3, 0}, false},       // 2
+		{"2.1", Version{2, 1}, true},      // 3
+		{"2.1", Version{2, 2}, false},     // 4
+		{"1-2", Version{2, 9}, true},      // 5
+		{"1-2", Version{0, 9}, false},     // 6
+		{"1.5-2.1", Version{1, 4}, false}, // 7
+		{"1.5-2.1", Version{2, 1}, true},  // 8
+		{"3-", Version{42, 0}, true},      // 9
+		{"3-", Version{2, 9}, false},      // 10
+		{"-2", Version{0, 0}, true},       // 11
+		{"-2", Version{3, 0}, false},      // 12
+	}
+
+	for i, test := range tests {
+		vr, err := parseVersionRange(test.versions)
+		if err != nil {
+			t.Errorf("tests[%v]: Expected no error, received '%v'.", i, err)
+			continue
+		}
+		if vr.contains(test.version) != test.contains {
+			t.Errorf("tests[%v]: Expected contains to be '%v', received '%v'.", i, test.contains, !test.contains)
+		}
+	}
+}
+
+func TestRouterVersioning_request(t *testing.T) {
+	router := NewRouter()
+	if router.Versioning() != 0 {
+		t.Errorf("Expected no versioning, received '%v'.", router.Versioning())
+	}
+	if router.VersionHeader() != "Api-Version" {
+		t.Errorf("Expected header 'Api-Version', received '%v'.", router.VersionHeader())
+	}
+	router.SetVersioning(VersionPath | VersionHeader | VersionAccept)
+
+	handler := func(name string) HandlerFunc {
+		return func(w http.ResponseWriter, req *Request) {
+			v, ok := req.Version()
+			if ok {
+				w.Header().Set("X-Version", v.String())
+			}
+			w.Header().Set("X-Route", name)
+		}
+	}
+	router.NewRoute().SetPath("/users").SetVersion("1").SetHandler(handler("v1"))
+	router.NewRoute().SetPath("/users").SetVersion("2.0-2.3").SetHandler(handler("v2"))
+	router.NewRoute().SetPath("/users").SetVersion("2.5").SetHandler(handler("v2.5"))
+	router.NewRoute().SetMatchSlashes(true).SetPath("/users/").SetVersion("4-").SetHandler(handler("v4"))
+	router.NewRoute().SetPath("/status").SetHandler(handler("status"))
+
+	type versionTest struct {
+		path     string
+		headers  http.Header
+		code     int
+		route    string
+		version  string
+		location string
+		vary     string
+	}
+
+	tests := []versionTest{
+		{ // 0
+			path:    "/v1/users",
+			route:   "v1",
+			version: "1",
+		},
+		{ // 1
+			path:    "/v2.1/users",
+			route:   "v2",
+			version: "2.1",
+		},
+		{ // 2
+			// Falls back to the latest compatible version.
+			path:    "/v2.4/users",
+			route:   "v2.5",
+			version: "2.5",
+		},
+		{ // 3
+			// No version with a matching major number.
+			path: "/v3/users",
+			code: http.StatusNotFound,
+		},
+		{ // 4
+			path:    "/users",
+			headers: http.Header{"Api-Version": {"2"}},
+			route:   "v2",
+			version: "2",
+			vary:    "Api-Version",
+		},
+		{ // 5
+			path:    "/users",
+			headers: http.Header{"Accept": {"text/html, application/vnd.example+json; version=1.2"}},
+			route:   "v1",
+			version: "1.2",
+			vary:    "Api-Version, Accept",
+		},
+		{ // 6
+			// The path takes precedence over headers.
+			path:    "/v1/users",
+			headers: http.Header{"Api-Version": {"2"}},
+			route:   "v1",
+			version: "1",
+		},
+		{ // 7
+			// Without a version, the latest is used.
+			path:    "/users/",
+			route:   "v4",
+			version: "4",
+			vary:    "Api-Version, Accept",
+		},
+		{ // 8
+			// Trailing slash redirects keep the version prefix.
+			path:     "/v7/users",
+			code:     http.StatusMovedPermanently,
+			location: "/v7/users/",
+		},
+		{ // 9
+			// The prefix is kept as it was requested.
+			path:     "/V7/users",
+			code:     http.StatusMovedPermanently,
+			location: "/V7/users/",
+		},
+		{ // 10
+			path:    "/v7/users/",
+			route:   "v4",
+			version: "7",
+		},
+		{ // 11
+			// Unversioned routes match any version.
+			path:    "/v9/status",
+			route:   "status",
+			version: "9",
+		},
+		{ // 12
+			path:  "/status",
+			route: "status",
+			vary:  "Api-Version, Accept",
+		},
+		{ // 13
+			// Invalid versions are ignored.
+			path:    "/users",
+			headers: http.Header{"Api-Version": {"latest"}},
+			code:    http.StatusMovedPermanently,
+			vary:    "Api-Version, Accept",
+		},
+	}
+
+	for i, test := range tests {
+		if test.code == 0 {
+			test.code = http.StatusOK
+		}
+		req, _ := http.NewRequest("GET", "http://example.com"+test.path, nil)
+		for k, v := range test.headers {
+			req.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.code {
+			t.Errorf("tests[%v]: Expected code '%v', received '%v'.", i, test.code, w.Code)
+		}
+		if w.Header().Get("X-Route") != test.route {
+			t.Errorf("tests[%v]: Expected route '%v', received '%v'.", i, test.route, w.Header().Get("X-Route"))
+		}
+		if w.Header().Get("X-Version") != test.version {
+			t.Errorf("tests[%v]: Expected version '%v', received '%v'.", i, test.version, w.Header().Get("X-Version"))
+		}
+		if test.location != "" && w.Header().Get("Location") != test.location {
+			t.Errorf("tests[%v]: Expected location '%v', received '%v'.", i, test.location, w.Header().Get("Location"))
+		}
+		if w.Header().Get("Vary") != test.vary {
+			t.Errorf("tests[%v]: Expected vary '%v', received '%v'.", i, test.vary, w.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestRouterDeprecateVersion(t *testing.T) {
+	deprecation := time.Date(2023, 6, 30, 23, 59, 59, 0, time.UTC)
+	sunset := time.Date(2024, 6, 30, 23, 59, 59, 0, time.UTC)
+
+	router := NewRouter().SetVersioning(VersionHeader)
+	router.DeprecateVersion("bogus", deprecation, sunset)
+	if router.Error() == nil {
+		t.Error("Expected an error, received none.")
+	}
+	router.UnsetError()
+	router.DeprecateVersion("-1", deprecation, sunset)
+	if router.Error() != nil {
+		t.Errorf("Expected no error, received '%v'.", router.Error())
+	}
+	router.NewRoute().SetPath("/").SetHandler(func(w http.ResponseWriter, req *Request) {})
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Api-Version", "1.3")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Deprecation") != "@1688169599" {
+		t.Errorf("Expected deprecation '@1688169599', received '%v'.", w.Header().Get("Deprecation"))
+	}
+	if w.Header().Get("Sunset") != "Sun, 30 Jun 2024 23:59:59 GMT" {
+		t.Errorf("Expected sunset 'Sun, 30 Jun 2024 23:59:59 GMT', received '%v'.", w.Header().Get("Sunset"))
+	}
+
+	req.Header.Set("Api-Version", "2")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Header().Get("Deprecation") != "" || w.Header().Get("Sunset") != "" {
+		t.Errorf("Expected no deprecation headers, received '%v'.", w.Header())
+	}
+}
+
+func TestRouterDeprecateVersionFunc(t *testing.T) {
+	router := NewRouter().SetVersioning(VersionHeader)
+	router.DeprecateVersionFunc("-1", func(w http.ResponseWriter, req *Request, v Version) {
+		if req.Route.Name() == "internal" {
+			return
+		}
+		w.Header().Set("Link", `</v2/docs>; rel="successor-version"`)
+		w.Header().Set("X-Deprecated-Version", v.String())
+	})
+	handler := func(w http.ResponseWriter, req *Request) {}
+	router.NewRoute().SetPath("/docs").SetHandler(handler)
+	router.NewRoute().SetName("internal").SetPath("/internal").SetHandler(handler)
+
+	type deprecationTest struct {
+		version string
+		path    string
+		link    string
+		header  string
+	}
+
+	tests := []deprecationTest{
+		{"1.3", "/docs", `</v2/docs>; rel="successor-version"`, "1.3"}, // 0
+		{"1.3", "/internal", "", ""},                                   // 1
+		{"2", "/docs", "", ""},                                         // 2
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest("GET", "http://example.com"+test.path, nil)
+		req.Header.Set("Api-Version", test.version)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Header().Get("Link") != test.link {
+			t.Errorf("requests[%v]: Expected link '%v', received '%v'.", i, test.link, w.Header().Get("Link"))
+		}
+		if w.Header().Get("X-Deprecated-Version") != test.header {
+			t.Errorf("requests[%v]: Expected version '%v', received '%v'.", i, test.header, w.Header().Get("X-Deprecated-Version"))
+		}
+	}
+}
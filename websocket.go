@@ -0,0 +1,390 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// webSocketGUID is appended to the client's key to compute the accept key, as
+// defined by RFC 6455.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// webSocketVersion is the only WebSocket protocol version supported.
+const webSocketVersion = "13"
+
+// defaultWebSocketReadLimit is the default maximum size of a message.
+const defaultWebSocketReadLimit = 16 << 20
+
+// Error messages related to WebSockets.
+const (
+	errInvalidMessageType = "routing: %d is not a valid WebSocket message type."
+	errControlTooLong     = "routing: WebSocket control frames can not exceed 125 bytes."
+)
+
+// webSocketSchemes maps the scheme of a request to the equivalent WebSocket
+// scheme.
+var webSocketSchemes = map[string]string{
+	"http":  "ws",
+	"https": "wss",
+}
+
+// The types of WebSocket messages, which are the opcodes of their frames.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	closeFrame    = 8
+	pingFrame     = 9
+	pongFrame     = 10
+)
+
+// WebSocket close codes, as defined by RFC 6455.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// Errors returned by UpgradeWebSocket.
+var (
+	// ErrBadHandshake is returned when the request is not a valid WebSocket
+	// handshake.
+	ErrBadHandshake = errors.New("routing: Request is not a valid WebSocket handshake.")
+	// ErrUnsupportedVersion is returned when the client requested a
+	// WebSocket version other than 13.
+	ErrUnsupportedVersion = errors.New("routing: WebSocket version is not supported.")
+)
+
+// A CloseError is returned by WebSocketConn.ReadMessage once the connection has
+// been closed by either side.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("routing: WebSocket closed with code %d: %s", e.Code, e.Reason)
+}
+
+// WebSocket is shorthand for matching only WebSocket handshakes, which are GET
+// requests with "Upgrade: websocket", "Connection: upgrade", and a
+// Sec-WebSocket-Version header.  Header names and tokens are not case
+// sensitive, and tokens can be part of a list, such as "keep-alive, Upgrade".
+// The version is checked by UpgradeWebSocket, so that clients using another
+// version can be told which one is supported.
+func (r *Route) WebSocket() *Route {
+	r.websocket = true
+	return r
+}
+
+// isWebSocketHandshake returns true if req is a WebSocket handshake.
+func isWebSocketHandshake(req *http.Request) bool {
+	return req.Method == "GET" &&
+		headerHasToken(req.Header, "Connection", "upgrade") &&
+		headerHasToken(req.Header, "Upgrade", "websocket") &&
+		req.Header.Get("Sec-Websocket-Version") != ""
+}
+
+// headerHasToken returns true if any of the comma separated values of the
+// named header is token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range splitHeader(h[http.CanonicalHeaderKey(name)]) {
+		if strings.EqualFold(v, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpgradeWebSocket completes the WebSocket handshake, and returns the
+// resulting connection.  If protocols are given, the first one that the
+// client also offers in its Sec-WebSocket-Protocol header is selected.
+//
+// If the request is not a valid handshake, a 400 response is sent and
+// ErrBadHandshake is returned.  If the client requested an unsupported
+// version, a 426 response listing the supported version is sent, and
+// ErrUnsupportedVersion is returned.  Handlers that accept connections from
+// browsers should check the Origin header before upgrading.
+func UpgradeWebSocket(w http.ResponseWriter, req *Request, protocols ...string) (*WebSocketConn, error) {
+	if !isWebSocketHandshake(req.Request) {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if req.Request.Header.Get("Sec-Websocket-Version") != webSocketVersion {
+		w.Header().Set("Sec-Websocket-Version", webSocketVersion)
+		http.Error(w, http.StatusText(http.StatusUpgradeRequired), http.StatusUpgradeRequired)
+		return nil, ErrUnsupportedVersion
+	}
+	key := req.Request.Header.Get("Sec-Websocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	var protocol string
+	offered := splitHeader(req.Request.Header["Sec-Websocket-Protocol"])
+	for _, p := range protocols {
+		if sliceContainsString(offered, p) {
+			protocol = p
+			break
+		}
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	accept := sha1.Sum([]byte(key + webSocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n"
+	if protocol != "" {
+		response += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	rw.WriteString(response + "\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &WebSocketConn{
+		conn:      conn,
+		r:         rw.Reader,
+		protocol:  protocol,
+		readLimit: defaultWebSocketReadLimit,
+	}, nil
+}
+
+// A WebSocketConn is a server side WebSocket connection.  Messages can be
+// written concurrently with reading, but only one goroutine may read at a
+// time.
+type WebSocketConn struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	protocol  string
+	readLimit int64
+
+	mu     sync.Mutex // Guards writes to conn, and closed
+	closed bool
+}
+
+// Subprotocol returns the subprotocol selected during the handshake, if any.
+func (c *WebSocketConn) Subprotocol() string {
+	return c.protocol
+}
+
+// SetReadLimit sets the maximum size of a message read from the client.
+// Larger messages close the connection with CloseMessageTooBig.  The default
+// is 16 MiB.
+func (c *WebSocketConn) SetReadLimit(n int64) *WebSocketConn {
+	c.readLimit = n
+	return c
+}
+
+// ReadLimit returns the maximum size of a message read from the client.
+func (c *WebSocketConn) ReadLimit() int64 {
+	return c.readLimit
+}
+
+// SetDeadline sets the read and write deadlines of the underlying connection.
+func (c *WebSocketConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// ReadMessage reads the next text or binary message, reassembling fragmented
+// messages.  Pings are answered automatically, and pongs are ignored.  Once
+// the connection is closed, a *CloseError is returned.
+func (c *WebSocketConn) ReadMessage() (int, []byte, error) {
+	var msgType int
+	var msg []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch opcode {
+		case pingFrame:
+			if err := c.writeFrame(pongFrame, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case pongFrame:
+			continue
+		case closeFrame:
+			closeErr := &CloseError{Code: CloseNoStatus}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Reason = string(payload[2:])
+			}
+			c.Close(closeErr.Code, "")
+			return 0, nil, closeErr
+		case 0:
+			if msgType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		case TextMessage, BinaryMessage:
+			if msgType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			msgType = opcode
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "unknown opcode")
+		}
+
+		if int64(len(msg)+len(payload)) > c.readLimit {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			if msgType == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8")
+			}
+			return msgType, msg, nil
+		}
+	}
+}
+
+// readFrame reads a single frame, and unmasks its payload.
+func (c *WebSocketConn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "frame not masked")
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+	}
+	if opcode >= closeFrame && (!fin || length > 125) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if length > c.readLimit {
+		return false, 0, nil, c.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage writes data as a single text or binary message.
+func (c *WebSocketConn) WriteMessage(msgType int, data []byte) error {
+	if msgType != TextMessage && msgType != BinaryMessage {
+		return fmt.Errorf(errInvalidMessageType, msgType)
+	}
+	return c.writeFrame(msgType, data)
+}
+
+// Ping sends a ping with the given payload, which must be at most 125 bytes.
+func (c *WebSocketConn) Ping(data []byte) error {
+	if len(data) > 125 {
+		return fmt.Errorf(errControlTooLong)
+	}
+	return c.writeFrame(pingFrame, data)
+}
+
+// writeFrame writes a single, unfragmented and unmasked frame.
+func (c *WebSocketConn) writeFrame(opcode int, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	return writeWebSocketFrame(c.conn, opcode, payload)
+}
+
+// writeWebSocketFrame writes a final frame without a mask to w.
+func writeWebSocketFrame(w io.Writer, opcode int, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | byte(opcode)
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if _, err := w.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close sends a close frame with the given code and reason, and closes the
+// connection.  A code of CloseNoStatus sends a close frame without a code.
+// Closing an already closed connection does nothing.
+func (c *WebSocketConn) Close(code int, reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	var payload []byte
+	if code != CloseNoStatus {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+	}
+	writeWebSocketFrame(c.conn, closeFrame, payload)
+	return c.conn.Close()
+}
+
+// fail closes the connection due to a protocol violation by the client, and
+// returns the resulting CloseError.
+func (c *WebSocketConn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return &CloseError{Code: code, Reason: reason}
+}
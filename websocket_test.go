@@ -0,0 +1,230 @@
+// Copyright 2013 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package routing
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeClientFrame writes a masked frame, as sent by a client.
+func writeClientFrame(w io.Writer, fin bool, opcode int, payload []byte) error {
+	b := []byte{byte(opcode), 0x80 | byte(len(payload))}
+	if fin {
+		b[0] |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	b = append(b, mask...)
+	for i, v := range payload {
+		b = append(b, v^mask[i%4])
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readServerFrame reads an unmasked frame, as sent by the server.
+func readServerFrame(r io.Reader) (int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err := io.ReadFull(r, payload)
+	return int(header[0] & 0x0f), payload, err
+}
+
+func TestRouteWebSocket(t *testing.T) {
+	handler := func(w http.ResponseWriter, req *Request) {}
+	router := NewRouter()
+	secure := router.NewRoute().SetSchemes("wss").SetPath("/live").SetHandler(handler)
+	ws := router.NewRoute().SetPath("/live").WebSocket().SetHandler(handler)
+	plain := router.NewRoute().SetPath("/live").SetHandler(handler)
+
+	type webSocketTest struct {
+		method string
+		tls    bool
+		header http.Header
+		route  *Route
+	}
+
+	handshake := http.Header{
+		"Connection":            {"keep-alive, Upgrade"},
+		"Upgrade":               {"WebSocket"},
+		"Sec-Websocket-Version": {"13"},
+	}
+	tests := []webSocketTest{
+		{"GET", false, handshake, ws},     // 0
+		{"GET", true, handshake, secure},  // 1
+		{"POST", false, handshake, plain}, // 2
+		{"GET", false, http.Header{"Connection": {"upgrade"}, "Upgrade": {"websocket"}}, plain},                                     // 3
+		{"GET", false, http.Header{"Connection": {"keep-alive"}, "Upgrade": {"websocket"}, "Sec-Websocket-Version": {"13"}}, plain}, // 4
+		{"GET", false, http.Header{"Connection": {"Upgrade"}, "Upgrade": {"h2c"}, "Sec-Websocket-Version": {"13"}}, plain},          // 5
+		{"GET", true, nil, plain}, // 6
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest(test.method, "http://example.com/live", nil)
+		if test.tls {
+			req.TLS = new(tls.ConnectionState)
+		}
+		req.Header = test.header
+		if m, err := router.Match(req); err != nil || m.Route != test.route {
+			t.Errorf("tests[%v]: Expected route '%p', received '%v' and error '%v'.", i, test.route, m, err)
+		}
+	}
+
+	route := router.NewRoute().SetSchemes("wss").SetHost("example.com").SetPath("/live")
+	if u, _ := route.URL(nil); u.String() != "wss://example.com/live" {
+		t.Errorf("Expected URL 'wss://example.com/live', received '%v'.", u)
+	}
+	if router.NewRoute().SetSchemes("ftp").Error() == nil {
+		t.Error("Expected an error for scheme 'ftp', received none.")
+	}
+}
+
+func TestUpgradeWebSocket(t *testing.T) {
+	done := make(chan error, 1)
+	router := NewRouter()
+	router.NewRoute().SetPath("/echo").WebSocket().SetHandler(func(w http.ResponseWriter, req *Request) {
+		conn, err := UpgradeWebSocket(w, req, "v2.chat", "superchat", "chat")
+		if err != nil {
+			done <- err
+			return
+		}
+		if conn.Subprotocol() != "superchat" {
+			t.Errorf("Expected subprotocol 'superchat', received '%v'.", conn.Subprotocol())
+		}
+		for {
+			msgType, msg, err := conn.ReadMessage()
+			if err != nil {
+				done <- err
+				return
+			}
+			conn.WriteMessage(msgType, msg)
+		}
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// An unsupported version is rejected with the supported one.
+	req, _ := http.NewRequest("GET", server.URL+"/echo", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "8")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("Expected status '426' with version '13', received '%v' and '%v'.", resp.StatusCode, resp.Header.Get("Sec-WebSocket-Version"))
+	}
+	if err := <-done; err != ErrUnsupportedVersion {
+		t.Errorf("Expected error '%v', received '%v'.", ErrUnsupportedVersion, err)
+	}
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /echo HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: superchat, chat\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err = http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, received '%v'.", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Expected status '101' with the RFC 6455 accept key, received '%v' and '%v'.", resp.StatusCode, resp.Header)
+	}
+
+	// A fragmented message, interrupted by a ping, is reassembled.
+	writeClientFrame(conn, false, TextMessage, []byte("hel"))
+	writeClientFrame(conn, true, pingFrame, []byte("are you there"))
+	writeClientFrame(conn, true, 0, []byte("lo"))
+	if opcode, payload, _ := readServerFrame(r); opcode != pongFrame || string(payload) != "are you there" {
+		t.Errorf("Expected a pong, received opcode '%v' with '%s'.", opcode, payload)
+	}
+	if opcode, payload, _ := readServerFrame(r); opcode != TextMessage || string(payload) != "hello" {
+		t.Errorf("Expected text 'hello', received opcode '%v' with '%s'.", opcode, payload)
+	}
+	writeClientFrame(conn, true, BinaryMessage, []byte(strings.Repeat("x", 125)))
+	if opcode, payload, _ := readServerFrame(r); opcode != BinaryMessage || len(payload) != 125 {
+		t.Errorf("Expected 125 bytes of binary, received opcode '%v' with '%v' bytes.", opcode, len(payload))
+	}
+
+	// Closing is echoed, and ends the handler's read loop.
+	writeClientFrame(conn, true, closeFrame, []byte{0x03, 0xe8, 'b', 'y', 'e'})
+	if opcode, payload, _ := readServerFrame(r); opcode != closeFrame || binary.BigEndian.Uint16(payload) != CloseNormal {
+		t.Errorf("Expected a normal close, received opcode '%v' with '%v'.", opcode, payload)
+	}
+	var closeErr *CloseError
+	if err := <-done; !errors.As(err, &closeErr) || closeErr.Code != CloseNormal || closeErr.Reason != "bye" {
+		t.Errorf("Expected a close error with code '1000' and reason 'bye', received '%v'.", err)
+	}
+}
+
+func TestUpgradeWebSocket_timeout(t *testing.T) {
+	done := make(chan error, 2)
+	handler := func(w http.ResponseWriter, req *Request) {
+		conn, err := UpgradeWebSocket(w, req)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close(CloseNormal, "")
+		// The timeout no longer applies once the connection is upgraded.
+		time.Sleep(100 * time.Millisecond)
+		if err := req.Request.Context().Err(); err != nil {
+			done <- err
+			return
+		}
+		done <- conn.WriteMessage(TextMessage, []byte("still here"))
+	}
+	router := NewRouter().SetTimeout(time.Second)
+	router.NewRoute().SetPath("/router").WebSocket().SetHandler(handler)
+	router.NewRoute().SetPath("/route").WebSocket().SetTimeout(50 * time.Millisecond).SetHandler(handler)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	for _, path := range []string{"/router", "/route"} {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+		if err != nil {
+			t.Fatalf("Expected no error, received '%v'.", err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("%v: Expected status '101', received '%v' and error '%v'.", path, resp, err)
+		}
+		if err := <-done; err != nil {
+			t.Errorf("%v: Expected no error, received '%v'.", path, err)
+		}
+		if opcode, payload, _ := readServerFrame(r); opcode != TextMessage || string(payload) != "still here" {
+			t.Errorf("%v: Expected text 'still here', received opcode '%v' with '%s'.", path, opcode, payload)
+		}
+	}
+}